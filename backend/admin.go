@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"slices"
@@ -160,9 +159,8 @@ func (svc *serviceContext) adminSearch(c *gin.Context) {
 		}
 	}
 
-	if len(filters) > 0 {
-		payload["filter"] = strings.Join(filters, " AND ")
-	}
+	filters = append(filters, notDeletedFilter)
+	payload["filter"] = strings.Join(filters, " AND ")
 
 	log.Printf("INFO: search payload [%+v]", payload)
 	url := fmt.Sprintf("%s/indexes/works/search", svc.IndexURL)
@@ -232,6 +230,7 @@ func (svc *serviceContext) adminImpersonateUser(c *gin.Context) {
 	}
 
 	jsonResp.User.Role = "user"
+	jsonResp.User.ImpersonatedBy = adminClaims.ComputeID
 	signedStr, jwtErr := svc.mintUserJWT(&jsonResp.User)
 	if jwtErr != nil {
 		log.Printf("ERROR: unable to generate JWT for impersonated user %s: %s", tgtComputeID, jwtErr.Error())
@@ -239,6 +238,7 @@ func (svc *serviceContext) adminImpersonateUser(c *gin.Context) {
 		return
 	}
 
+	svc.publishSystemAudit(adminClaims.auditActor(), sysAuditImpersonate, tgtComputeID, "", "")
 	log.Printf("INFO: impersonate jwt: %s", signedStr)
 	c.SetCookie("libra3_impersonate_jwt", signedStr, 10, "/", "", false, false)
 	c.SetSameSite(http.SameSiteLaxMode)
@@ -249,6 +249,7 @@ func (svc *serviceContext) adminUpdatePublishedDate(c *gin.Context) {
 	workID := c.Param("id")
 	var dateReq struct {
 		NewDate string `json:"newDate"`
+		Version string `json:"version"`
 	}
 	err := c.ShouldBindJSON(&dateReq)
 	if err != nil {
@@ -264,17 +265,21 @@ func (svc *serviceContext) adminUpdatePublishedDate(c *gin.Context) {
 		return
 	}
 
-	claims := getJWTClaims(c)
-	svc.auditDatePublished(claims.ComputeID, tgtObj, dateReq.NewDate)
+	if svc.isCurrentVersion(c, tgtObj, dateReq.Version) == false {
+		return
+	}
 
+	claims := getJWTClaims(c)
 	fields := tgtObj.Fields()
+	origDate := fields["publish-date"]
 	fields["publish-date"] = dateReq.NewDate
 	_, err = svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields)
 	if err != nil {
 		log.Printf("ERROR: update date published for work %s failed: %s", workID, err.Error())
-		c.String(http.StatusInternalServerError, fmt.Sprintf("publish date update failed: %s", err.Error()))
+		svc.sendUpdateError(c, workID, fmt.Errorf("publish date update failed: %w", err))
 		return
 	}
+	svc.auditDatePublished(claims.auditActor(), tgtObj, origDate, dateReq.NewDate)
 
 	c.String(http.StatusOK, fields["publish-date"])
 }
@@ -285,13 +290,21 @@ func (svc *serviceContext) adminUnpublishWork(c *gin.Context) {
 	log.Printf("INFO: admin %s requests unpublish work %s", claims.ComputeID, workID)
 
 	log.Printf("INFO: get work %s for unpublish", workID)
-	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
 	if err != nil {
 		log.Printf("ERROR: unable to get work %s: %s", workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	var unpubReq withdrawRequest
+	if bindOptionalJSON(c, &unpubReq) == false {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, unpubReq.Version) == false {
+		return
+	}
+
 	fields := tgtObj.Fields()
 	if fields["draft"] == "true" {
 		log.Printf("INFO: %s is not published", workID)
@@ -299,41 +312,191 @@ func (svc *serviceContext) adminUnpublishWork(c *gin.Context) {
 		return
 	}
 
-	svc.auditPublicationChange(claims.ComputeID, tgtObj, false)
-
 	fields["draft"] = "true"
 	delete(fields, "publish-date")
 	_, err = svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields)
 	if err != nil {
 		log.Printf("ERROR: unpublish %s failed: %s", workID, err.Error())
-		c.String(http.StatusInternalServerError, fmt.Sprintf("unpublish failed: %s", err.Error()))
+		svc.sendUpdateError(c, workID, fmt.Errorf("unpublish failed: %w", err))
 		return
 	}
+	svc.auditPublicationChange(claims.auditActor(), tgtObj, false)
 	svc.publishEvent(uvalibrabus.EventWorkUnpublish, svc.Namespace, tgtObj.Id())
+	svc.recordWithdrawal(claims.auditActor(), tgtObj, withdrawnUnpublished, unpubReq.Reason)
+
+	if unpubWork, err := svc.parseWork(tgtObj, false); err != nil {
+		log.Printf("ERROR: unable to parse work %s for unpublish notice: %s", workID, err.Error())
+	} else {
+		notice := svc.newEmailData(unpubWork)
+		notice.Reason = strings.TrimSpace(unpubReq.Reason)
+		if err := svc.sendNotification(claims.auditActor(), workID, unpublishedNotice, depositorEmail(tgtObj), notice); err != nil {
+			log.Printf("ERROR: unable to send unpublish notice for work %s: %s", workID, err.Error())
+		}
+	}
+
+	if err := svc.hideDOI(workID, fields["doi"]); err != nil {
+		log.Printf("ERROR: unable to hide doi for unpublished work %s: %s", workID, err.Error())
+	}
 	c.String(http.StatusOK, "unpublished")
 }
 
+// adminDeleteWork moves a work into the trash. It is hidden everywhere but the admin trash listing
+// until it is restored or purged once the retention period has passed.
 func (svc *serviceContext) adminDeleteWork(c *gin.Context) {
 	workID := c.Param("id")
+	claims := getJWTClaims(c)
 	log.Printf("INFO: get %s work %s for deletion", svc.Namespace, workID)
-	delObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent)
+	delObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
 	if err != nil {
 		log.Printf("ERROR: unablle to get  %s work %s: %s", svc.Namespace, workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if isTrashed(delObj) {
+		log.Printf("INFO: work %s is already in the trash", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s has already been deleted", workID))
+		return
+	}
 
-	log.Printf("INFO: delete %s work %s", svc.Namespace, workID)
-	_, err = svc.EasyStore.ObjectDelete(delObj, uvaeasystore.AllComponents)
-	if err != nil {
+	var delReq withdrawRequest
+	if bindOptionalJSON(c, &delReq) == false {
+		return
+	}
+
+	if err := svc.moveToTrash(claims.auditActor(), delObj); err != nil {
 		log.Printf("ERROR: unablle to delete  %s work %s: %s", svc.Namespace, workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	fields := delObj.Fields()
+
+	// works that were never public have nothing pointing at them and need no tombstone
+	if fields["draft"] == "false" || fields["doi"] != "" || svc.getWithdrawal(workID) != nil {
+		svc.recordWithdrawal(claims.auditActor(), delObj, withdrawnDeleted, delReq.Reason)
+	}
+
+	// DOIs cannot be deleted; move it out of the findable state so it no longer appears in DataCite search
+	if err := svc.hideDOI(workID, fields["doi"]); err != nil {
+		log.Printf("ERROR: unable to hide doi for deleted work %s: %s", workID, err.Error())
+	}
 	c.String(http.StatusOK, "deleted")
 }
 
+type depositorChangeRequest struct {
+	versionRequest
+	ComputeID    string `json:"computeID"`
+	UpdateAuthor bool   `json:"updateAuthor"`
+}
+
+// adminChangeDepositor transfers ownership of a work to another user. The author compute ID and name
+// can optionally be changed to match the new depositor.
+func (svc *serviceContext) adminChangeDepositor(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	var chgReq depositorChangeRequest
+	if err := c.ShouldBindJSON(&chgReq); err != nil {
+		log.Printf("ERROR: bad payload for depositor change: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	chgReq.ComputeID = strings.ToLower(strings.TrimSpace(chgReq.ComputeID))
+	log.Printf("INFO: admin %s requests work %s depositor change to %s", claims.ComputeID, workID, chgReq.ComputeID)
+	if chgReq.ComputeID == "" {
+		c.String(http.StatusBadRequest, "compute id is required")
+		return
+	}
+
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject depositor change of deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, chgReq.Version) == false {
+		return
+	}
+
+	origWork, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tgtObj.Fields()["depositor"] == chgReq.ComputeID && (chgReq.UpdateAuthor == false || origWork.Author.ComputeID == chgReq.ComputeID) {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is already the depositor of %s", chgReq.ComputeID, workID))
+		return
+	}
+
+	// an open registration follows the work, and a student may only have one
+	if tgtObj.Fields()["source"] == "optional" && tgtObj.Fields()["draft"] == "true" {
+		open, err := svc.findOpenRegistrations([]string{chgReq.ComputeID})
+		if err != nil {
+			log.Printf("ERROR: unable to check open registrations for %s: %s", chgReq.ComputeID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if rec, found := open[chgReq.ComputeID]; found && rec.WorkID != workID {
+			log.Printf("INFO: reject work %s depositor change; %s has an open registration for %s", workID, chgReq.ComputeID, rec.WorkID)
+			c.String(http.StatusConflict, fmt.Sprintf("%s already has an open registration: work %s", chgReq.ComputeID, rec.WorkID))
+			return
+		}
+	}
+
+	if err := svc.Protected.refreshJWT(svc.JWTKey); err != nil {
+		log.Printf("ERROR: unable to refresh protected services jwt: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, userErr := svc.getUserDetails(chgReq.ComputeID)
+	if userErr != nil {
+		log.Printf("INFO: unable to change work %s depositor to %s: %s", workID, chgReq.ComputeID, userErr.Message)
+		c.String(userErr.StatusCode, userErr.Message)
+		return
+	}
+
+	updatedWork := *origWork.ETDWork
+	if chgReq.UpdateAuthor {
+		updatedWork.Author.ComputeID = user.ComputeID
+		updatedWork.Author.FirstName = user.FirstName
+		updatedWork.Author.LastName = user.LastName
+	}
+	updatedObj, err := svc.saveWorkChange(claims.auditActor(), tgtObj, *origWork.ETDWork, updatedWork, &user.ComputeID)
+	if err != nil {
+		log.Printf("ERROR: unable to change work %s depositor: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+
+	// an open registration follows the work to the new depositor
+	if tgtObj.Fields()["source"] == "optional" {
+		resp := svc.DB.Model(&registrationStudent{}).Where("work_id=? and completed_at is null and cancelled_at is null", workID).Update("compute_id", user.ComputeID)
+		if resp.Error != nil {
+			log.Printf("ERROR: unable to update registration for work %s to %s: %s", workID, user.ComputeID, resp.Error.Error())
+		}
+	}
+
+	if chgReq.UpdateAuthor && updatedObj.Fields()["draft"] == "false" && updatedObj.Fields()["doi"] != "" {
+		if err := svc.updateDOIMetadata(updatedObj); err != nil {
+			log.Printf("ERROR: unable to update doi metadata for work %s: %s", workID, err.Error())
+		}
+	}
+
+	resp, _ := svc.parseWork(updatedObj, true)
+	c.Header("ETag", fmt.Sprintf("\"%s\"", updatedObj.VTag()))
+	c.JSON(http.StatusOK, resp)
+}
+
 func (svc *serviceContext) adminUpdateMimeTypes(c *gin.Context) {
+	claims := getJWTClaims(c)
 	var req []string
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("INFO: invalid request for update mime types: %s", err.Error())
@@ -371,6 +534,9 @@ func (svc *serviceContext) adminUpdateMimeTypes(c *gin.Context) {
 		}
 	}
 
+	if len(added) > 0 || len(removed) > 0 {
+		svc.publishSystemAudit(claims.auditActor(), sysAuditMimeTypes, "", strings.Join(svc.MimeTypes, ", "), strings.Join(req, ", "))
+	}
 	svc.MimeTypes = req
 	log.Printf("INFO: new list of supported mime types %v", req)
 	c.JSON(http.StatusOK, req)
@@ -379,16 +545,9 @@ func (svc *serviceContext) adminUpdateMimeTypes(c *gin.Context) {
 func (svc *serviceContext) replaceFile(c *gin.Context) {
 	workID := c.Param("id")
 	fileName := c.Param("name")
-	form, err := c.MultipartForm()
-	if err != nil {
-		log.Printf("INFO: unable to get multipart form for file replace %s", err.Error())
-		c.String(http.StatusBadRequest, err.Error())
-		return
-	}
-	formFile := form.File["file"][0]
 	log.Printf("INFO: received request to replace file %s from work %s", fileName, workID)
 
-	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Files)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: unable to get %s work %s for file replace: %s", svc.Namespace, workID, err.Error())
 		if strings.Contains(err.Error(), "not exist") {
@@ -399,23 +558,36 @@ func (svc *serviceContext) replaceFile(c *gin.Context) {
 		return
 	}
 
-	src, err := formFile.Open()
-	if err != nil {
-		log.Printf("ERROR: unable to open uploaded file: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject file replace in deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
 		return
 	}
-	defer src.Close()
 
-	fileBytes, err := io.ReadAll(src)
-	if err != nil {
-		log.Printf("ERROR: unable to read upload file %s: %s", formFile.Filename, err.Error())
+	upload, uploadErr := svc.stageUpload(c)
+	if uploadErr != nil {
+		log.Printf("INFO: unable to receive replacement for file %s: %s", fileName, uploadErr.Message)
+		c.String(uploadErr.StatusCode, uploadErr.Message)
 		return
 	}
+	defer upload.remove()
 
-	mimeType := http.DetectContentType(fileBytes)
-	esBlob := uvaeasystore.NewEasyStoreBlob(fileName, mimeType, fileBytes)
-	if err := svc.EasyStore.FileUpdate(svc.Namespace, workID, esBlob); err != nil {
+	if typeErr := svc.checkMimeType(c, upload); typeErr != nil {
+		log.Printf("INFO: reject upload %s to work %s: %s", fileName, workID, typeErr.Message)
+		c.String(typeErr.StatusCode, typeErr.Message)
+		return
+	}
+
+	if sizeErr := svc.checkWorkSize(tgtObj, upload.size, fileName); sizeErr != nil {
+		log.Printf("INFO: reject replacement of %s in work %s: %s", fileName, workID, sizeErr.Message)
+		c.String(sizeErr.StatusCode, sizeErr.Message)
+		return
+	}
+
+	err = svc.storeUpload(upload, fileName, func(esBlob uvaeasystore.EasyStoreBlob) error {
+		return svc.EasyStore.FileUpdate(svc.Namespace, workID, esBlob)
+	})
+	if err != nil {
 		log.Printf("ERROR: unable to update file  %s: %s", fileName, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -423,7 +595,7 @@ func (svc *serviceContext) replaceFile(c *gin.Context) {
 
 	// NOTE: this call has already been thru user or admin middleware, so claims will be present
 	claims := getJWTClaims(c)
-	svc.auditFileReplace(claims.ComputeID, tgtObj, fileName)
+	svc.auditFileReplace(claims.auditActor(), tgtObj, fileName)
 
 	c.String(http.StatusOK, "replaced")
 }
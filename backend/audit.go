@@ -1,24 +1,124 @@
 package main
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/uvalib/easystore/uvaeasystore"
 	librametadata "github.com/uvalib/libra-metadata"
 	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm"
 )
 
+// auditActor identifies who made an audited change. When the change was made with an impersonated
+// token, impersonatedBy is the compute ID of the admin acting as the user.
+type auditActor struct {
+	computeID      string
+	impersonatedBy string
+}
+
+// systemActor is the actor for changes made by the service itself, such as embargo release and purges
+var systemActor = auditActor{computeID: systemComputeID}
+
+// auditContext holds the data common to all audits of one change. Audits published with the same
+// context share a change id and are grouped into a single change set in the audit history. A deferred
+// context collects its audits until commitAudits is called, so a change that fails to save is never audited.
 type auditContext struct {
-	computeID string
-	namespace string
-	workID    string
+	computeID      string
+	impersonatedBy string
+	namespace      string
+	workID         string
+	changeID       string
+	pending        *pendingAudits
+}
+
+// pendingAudits are the audits collected by a deferred audit context
+type pendingAudits struct {
+	events    []uvalibrabus.UvaAuditEvent
+	committed bool
+}
+
+func (svc *serviceContext) newAuditContext(actor auditActor, namespace string, workID string) auditContext {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("WARNING: unable to generate audit change id for work %s: %s", workID, err.Error())
+	}
+	return auditContext{computeID: actor.computeID, impersonatedBy: actor.impersonatedBy,
+		namespace: namespace, workID: workID, changeID: hex.EncodeToString(idBytes)}
+}
+
+// deferred returns a copy of the context that collects audits instead of storing them
+func (auditCtx auditContext) deferred() auditContext {
+	auditCtx.pending = &pendingAudits{}
+	return auditCtx
 }
 
+// commitAudits stores the audits collected by a deferred context. Call it once the change has been saved;
+// audits published with the context after that are stored immediately.
+func (svc *serviceContext) commitAudits(auditCtx auditContext) {
+	if auditCtx.pending == nil || auditCtx.pending.committed {
+		return
+	}
+	auditCtx.pending.committed = true
+	for _, auditEvt := range auditCtx.pending.events {
+		svc.publishAuditChange(auditCtx, auditEvt)
+	}
+}
+
+// audit is a locally stored audit event. PublishedAt is set once the outbox has delivered the event to the bus.
+type audit struct {
+	ID             uint64     `json:"-"`
+	Namespace      string     `json:"namespace"`
+	WorkID         string     `json:"oid"`
+	Who            string     `json:"who"`
+	ImpersonatedBy string     `json:"impersonatedBy,omitempty"`
+	FieldName      string     `json:"fieldName"`
+	Before         string     `json:"before"`
+	After          string     `json:"after"`
+	EventTime      time.Time  `json:"eventTime"`
+	ChangeID       string     `json:"changeID,omitempty"`
+	PublishedAt    *time.Time `json:"-"`
+}
+
+// auditFilter holds the optional filters of an audit history request
+type auditFilter struct {
+	field string
+	user  string
+	from  *time.Time
+	to    *time.Time
+}
+
+// matches applies the filter to an audit that was not loaded from the local audit store
+func (f auditFilter) matches(a audit) bool {
+	if f.field != "" && a.FieldName != f.field {
+		return false
+	}
+	if f.user != "" && a.Who != f.user && a.ImpersonatedBy != f.user {
+		return false
+	}
+	if f.from != nil && a.EventTime.Before(*f.from) {
+		return false
+	}
+	if f.to != nil && a.EventTime.Before(*f.to) == false {
+		return false
+	}
+	return true
+}
+
+// getAudits returns the audit history for a work. Audits from the local audit store are merged with
+// the history held by the external audit service from before the work was first audited locally.
+// Supported filters are field, user and a from/to date range. With grouped=true the audits are
+// returned as change sets with readable labels, word diffs of long text and explicit file operations.
 func (svc *serviceContext) getAudits(c *gin.Context) {
 	workID := c.Param("id")
 
@@ -26,37 +126,131 @@ func (svc *serviceContext) getAudits(c *gin.Context) {
 	// 1) load the work and make sure it exists. Audit button is only on a work page so it exists by definition.
 	// 2) a check for ability to access work. Only admins and owners can view audit, which can always view the work.
 
+	grouped, _ := strconv.ParseBool(c.Query("grouped"))
+	filter := auditFilter{field: c.Query("field"), user: c.Query("user")}
+	for _, param := range []string{"from", "to"} {
+		dateStr := c.Query(param)
+		if dateStr == "" {
+			continue
+		}
+		filterDate, dateOnly, err := parseAuditDate(dateStr)
+		if err != nil {
+			log.Printf("INFO: invalid audit %s date %s: %s", param, dateStr, err.Error())
+			c.String(http.StatusBadRequest, fmt.Sprintf("invalid %s date %s", param, dateStr))
+			return
+		}
+		if param == "from" {
+			filter.from = &filterDate
+		} else {
+			// a date with no time includes the full day
+			if dateOnly {
+				filterDate = filterDate.Add(24 * time.Hour)
+			}
+			filter.to = &filterDate
+		}
+	}
+
+	query := svc.DB.Where("namespace=? and work_id=?", svc.Namespace, workID)
+	if filter.field != "" {
+		query = query.Where("field_name=?", filter.field)
+	}
+	if filter.user != "" {
+		query = query.Where("(who=? or impersonated_by=?)", filter.user, filter.user)
+	}
+	if filter.from != nil {
+		query = query.Where("event_time >= ?", *filter.from)
+	}
+	if filter.to != nil {
+		query = query.Where("event_time < ?", *filter.to)
+	}
+	var audits []audit
+	if err := query.Order("event_time asc, id asc").Find(&audits).Error; err != nil {
+		log.Printf("ERROR: unable to get audits for work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// every audit since the local store was added is recorded locally and forwarded to the audit
+	// service, so only remote audits older than the first local audit of the work are legacy history
+	var firstLocal sql.NullTime
+	if err := svc.DB.Raw("select min(event_time) from audits where namespace=? and work_id=?", svc.Namespace, workID).Scan(&firstLocal).Error; err != nil {
+		log.Printf("ERROR: unable to get first audit time for work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	remote, reqErr := svc.getRemoteAudits(workID)
+	if reqErr != nil {
+		if firstLocal.Valid == false {
+			c.String(reqErr.StatusCode, reqErr.Message)
+			return
+		}
+		log.Printf("WARNING: audit service history for work %s is unavailable; return local audits only: %s", workID, reqErr.Message)
+	}
+	legacy := make([]audit, 0)
+	for _, a := range remote {
+		if (firstLocal.Valid == false || a.EventTime.Before(firstLocal.Time)) && filter.matches(a) {
+			legacy = append(legacy, a)
+		}
+	}
+	if len(legacy) > 0 {
+		log.Printf("INFO: merge %d audit service events with %d local audits for work %s", len(legacy), len(audits), workID)
+		audits = append(legacy, audits...)
+		sort.SliceStable(audits, func(i, j int) bool { return audits[i].EventTime.Before(audits[j].EventTime) })
+	}
+
+	if grouped {
+		c.JSON(http.StatusOK, groupAudits(audits))
+		return
+	}
+	c.JSON(http.StatusOK, audits)
+}
+
+// getRemoteAudits returns the audit history of a work held by the external audit service. Remote
+// audits have no change ids so each is its own change set when grouped.
+func (svc *serviceContext) getRemoteAudits(workID string) ([]audit, *RequestError) {
 	resp, err := svc.sendGetRequest(fmt.Sprintf("%s?namespace=%s&oid=%s", svc.AuditQueryURL, svc.Namespace, workID))
 	if err != nil {
-		c.String(err.StatusCode, err.Message)
-		return
+		return nil, err
 	}
 
 	auditEvents, auditErr := librametadata.AuditsFromBytes(resp)
 	if auditErr != nil {
 		log.Printf("ERROR: unable to parse audit results: %s", auditErr.Error())
-		c.String(http.StatusInternalServerError, auditErr.Error())
-		return
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: auditErr.Error()}
+	}
+	audits := make([]audit, 0, len(*auditEvents))
+	for _, evt := range *auditEvents {
+		audits = append(audits, audit{Namespace: evt.Namespace, WorkID: evt.Oid, Who: evt.Who,
+			FieldName: evt.FieldName, Before: evt.Before, After: evt.After, EventTime: evt.EventTime})
 	}
+	return audits, nil
+}
 
-	c.JSON(http.StatusOK, auditEvents)
+// parseAuditDate accepts a full RFC3339 timestamp or a yyyy-mm-dd date. The bool result is true for dates with no time.
+func parseAuditDate(dateStr string) (time.Time, bool, error) {
+	if date, err := time.Parse(time.RFC3339, dateStr); err == nil {
+		return date, false, nil
+	}
+	date, err := time.Parse("2006-01-02", dateStr)
+	return date, true, err
 }
 
-func (svc *serviceContext) auditWorkUpdate(computeID string, etdUpdate etdUpdateRequest, origObj uvaeasystore.EasyStoreObject) {
+// auditWorkUpdate audits the changes made by a work update. The returned context is deferred and can be
+// used to add other audits caused by the same update to its change set; commitAudits stores them all.
+func (svc *serviceContext) auditWorkUpdate(actor auditActor, etdUpdate etdUpdateRequest, origObj uvaeasystore.EasyStoreObject) auditContext {
+	// setup an audit context that contains common data needed by all audit logic. the audits are only
+	// stored by commitAudits once the update has been saved
+	auditCtx := svc.newAuditContext(actor, svc.Namespace, origObj.Id()).deferred()
+
 	origWork, err := svc.parseWork(origObj, true)
 	if err != nil {
 		log.Printf("ERROR: unable to parse easystore work %s to generate audit events: %s", origObj.Id(), err.Error())
-	}
-
-	// setup an audit context that contains common data needed by all audit logic
-	auditCtx := auditContext{
-		computeID: computeID,
-		namespace: svc.Namespace,
-		workID:    origObj.Id(),
+		return auditCtx
 	}
 
 	svc.auditVisibiliy(auditCtx, origObj.Fields()["default-visibility"], etdUpdate.Visibility)
 	svc.auditWork(auditCtx, *origWork.ETDWork, etdUpdate.Work)
+	return auditCtx
 }
 
 func (svc *serviceContext) auditVisibiliy(auditCtx auditContext, origVis string, newVis string) {
@@ -67,23 +261,23 @@ func (svc *serviceContext) auditVisibiliy(auditCtx auditContext, origVis string,
 			Before:    origVis,
 			After:     newVis,
 		}
-		svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+		svc.publishAuditChange(auditCtx, auditEvt)
 	}
 }
 
-func (svc *serviceContext) auditDatePublished(computeID string, tgtObj uvaeasystore.EasyStoreObject, newDate string) {
-	if tgtObj.Fields()["publish-date"] != newDate {
+func (svc *serviceContext) auditDatePublished(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, origDate string, newDate string) {
+	if origDate != newDate {
 		auditEvt := uvalibrabus.UvaAuditEvent{
-			Who:       computeID,
+			Who:       actor.computeID,
 			FieldName: "publish-date",
-			Before:    tgtObj.Fields()["publish-date"],
+			Before:    origDate,
 			After:     newDate,
 		}
-		svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+		svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 	}
 }
 
-func (svc *serviceContext) auditPublicationChange(computeID string, tgtObj uvaeasystore.EasyStoreObject, published bool) {
+func (svc *serviceContext) auditPublicationChange(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, published bool) {
 	before := "true"
 	after := "false"
 	if published == false {
@@ -91,16 +285,16 @@ func (svc *serviceContext) auditPublicationChange(computeID string, tgtObj uvaea
 		after = "true"
 	}
 	auditEvt := uvalibrabus.UvaAuditEvent{
-		Who:       computeID,
+		Who:       actor.computeID,
 		FieldName: "draft",
 		Before:    before,
 		After:     after,
 	}
-	svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+	svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 
 }
 
-func (svc *serviceContext) auditFileAdd(computeID string, tgtObj uvaeasystore.EasyStoreObject, addFile string) {
+func (svc *serviceContext) auditFileAdd(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, addFile string) {
 	log.Printf("INFO: audit add file %s from work %s", addFile, tgtObj.Id())
 	orig := make([]string, 0)
 	updated := make([]string, 0)
@@ -111,15 +305,15 @@ func (svc *serviceContext) auditFileAdd(computeID string, tgtObj uvaeasystore.Ea
 	}
 	updated = append(updated, addFile)
 	auditEvt := uvalibrabus.UvaAuditEvent{
-		Who:       computeID,
+		Who:       actor.computeID,
 		FieldName: "files",
 		Before:    strings.Join(orig, ", "),
 		After:     strings.Join(updated, ", "),
 	}
-	svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+	svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 }
 
-func (svc *serviceContext) auditFileDelete(computeID string, tgtObj uvaeasystore.EasyStoreObject, delFile string) {
+func (svc *serviceContext) auditFileDelete(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, delFile string) {
 	log.Printf("INFO: audit delete file %s from work %s", delFile, tgtObj.Id())
 	orig := make([]string, 0)
 	updated := make([]string, 0)
@@ -131,15 +325,15 @@ func (svc *serviceContext) auditFileDelete(computeID string, tgtObj uvaeasystore
 		}
 	}
 	auditEvt := uvalibrabus.UvaAuditEvent{
-		Who:       computeID,
+		Who:       actor.computeID,
 		FieldName: "files",
 		Before:    strings.Join(orig, ", "),
 		After:     strings.Join(updated, ", "),
 	}
-	svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+	svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 }
 
-func (svc *serviceContext) auditFileRename(computeID string, tgtObj uvaeasystore.EasyStoreObject, origName, newName string) {
+func (svc *serviceContext) auditFileRename(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, origName, newName string) {
 	log.Printf("INFO: audit rename file %s from work %s to %s", origName, tgtObj.Id(), newName)
 	orig := make([]string, 0)
 	updated := make([]string, 0)
@@ -151,15 +345,15 @@ func (svc *serviceContext) auditFileRename(computeID string, tgtObj uvaeasystore
 		}
 	}
 	auditEvt := uvalibrabus.UvaAuditEvent{
-		Who:       computeID,
+		Who:       actor.computeID,
 		FieldName: "files",
 		Before:    strings.Join(orig, ", "),
 		After:     fmt.Sprintf("Rename file %s to %s", origName, newName),
 	}
-	svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+	svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 }
 
-func (svc *serviceContext) auditFileReplace(computeID string, tgtObj uvaeasystore.EasyStoreObject, replacedFile string) {
+func (svc *serviceContext) auditFileReplace(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, replacedFile string) {
 	log.Printf("INFO: audit replace file %s from work %s", replacedFile, tgtObj.Id())
 	orig := make([]string, 0)
 	for _, esBlob := range tgtObj.Files() {
@@ -167,12 +361,12 @@ func (svc *serviceContext) auditFileReplace(computeID string, tgtObj uvaeasystor
 		orig = append(orig, fileName)
 	}
 	auditEvt := uvalibrabus.UvaAuditEvent{
-		Who:       computeID,
+		Who:       actor.computeID,
 		FieldName: "files",
 		Before:    strings.Join(orig, ", "),
 		After:     fmt.Sprintf("Replace file %s", replacedFile),
 	}
-	svc.publishAuditEvent(tgtObj.Namespace(), tgtObj.Id(), auditEvt)
+	svc.publishAuditEvent(actor, tgtObj.Namespace(), tgtObj.Id(), auditEvt)
 }
 
 func (svc *serviceContext) auditWork(auditCtx auditContext, origWork librametadata.ETDWork, updatedWork librametadata.ETDWork) {
@@ -220,7 +414,7 @@ func (svc *serviceContext) auditStringField(auditCtx auditContext, fieldName str
 		Before:    origStr,
 		After:     newStr,
 	}
-	svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+	svc.publishAuditChange(auditCtx, auditEvt)
 }
 
 func (svc *serviceContext) auditStructField(auditCtx auditContext, fieldName string, origValue, newValue reflect.Value, structSliceIdx int) {
@@ -243,7 +437,7 @@ func (svc *serviceContext) auditStructField(auditCtx auditContext, fieldName str
 					Before:    "",
 					After:     structVal,
 				}
-				svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+				svc.publishAuditChange(auditCtx, auditEvt)
 			} else {
 				log.Printf("INFO: new value is blank, not auditing it")
 			}
@@ -266,7 +460,7 @@ func (svc *serviceContext) auditStructField(auditCtx auditContext, fieldName str
 						Before:    structVal,
 						After:     updateVal,
 					}
-					svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+					svc.publishAuditChange(auditCtx, auditEvt)
 				}
 			} else {
 				log.Printf("INFO: struct field %s orig %s vs nil", changeFieldName, structField)
@@ -276,7 +470,7 @@ func (svc *serviceContext) auditStructField(auditCtx auditContext, fieldName str
 					Before:    structVal,
 					After:     "",
 				}
-				svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+				svc.publishAuditChange(auditCtx, auditEvt)
 			}
 		}
 	}
@@ -338,11 +532,36 @@ func (svc *serviceContext) auditStringSlice(auditCtx auditContext, fieldName str
 		Before:    strings.Join(orig, "|"),
 		After:     strings.Join(upd, "|"),
 	}
-	svc.publishAuditEvent(auditCtx.namespace, auditCtx.workID, auditEvt)
+	svc.publishAuditChange(auditCtx, auditEvt)
+}
+
+// publishAuditEvent records a standalone audit that is its own change set
+func (svc *serviceContext) publishAuditEvent(actor auditActor, nameSpace, workID string, auditEvt uvalibrabus.UvaAuditEvent) {
+	auditCtx := auditContext{computeID: actor.computeID, impersonatedBy: actor.impersonatedBy, namespace: nameSpace, workID: workID}
+	svc.publishAuditChange(auditCtx, auditEvt)
 }
 
-func (svc *serviceContext) publishAuditEvent(nameSpace, workID string, audit uvalibrabus.UvaAuditEvent) {
-	auditDetail, _ := audit.Serialize()
+// publishAuditChange records the audit in the local audit store and queues it for the bus in the same
+// transaction, so an audit is never stored without also being forwarded
+func (svc *serviceContext) publishAuditChange(auditCtx auditContext, auditEvt uvalibrabus.UvaAuditEvent) {
+	if auditCtx.pending != nil && auditCtx.pending.committed == false {
+		auditCtx.pending.events = append(auditCtx.pending.events, auditEvt)
+		return
+	}
+	nameSpace := auditCtx.namespace
+	workID := auditCtx.workID
+	rec := audit{
+		Namespace:      nameSpace,
+		WorkID:         workID,
+		Who:            auditEvt.Who,
+		ImpersonatedBy: auditCtx.impersonatedBy,
+		FieldName:      auditEvt.FieldName,
+		Before:         auditEvt.Before,
+		After:          auditEvt.After,
+		EventTime:      time.Now(),
+		ChangeID:       auditCtx.changeID,
+	}
+	auditDetail, _ := auditEvt.Serialize()
 	evt := uvalibrabus.UvaBusEvent{
 		EventName:  uvalibrabus.EventFieldUpdate,
 		Namespace:  nameSpace,
@@ -350,12 +569,20 @@ func (svc *serviceContext) publishAuditEvent(nameSpace, workID string, audit uva
 		Detail:     auditDetail,
 	}
 
-	if svc.Events.DevMode {
-		log.Printf("INFO: dev mode work %s:%s send audit event data [%s] to bus [%s] with source [%s]", nameSpace, workID, auditDetail, svc.Events.BusName, svc.Events.EventSource)
-	} else {
-		err := svc.Events.Bus.PublishEvent(&evt)
-		if err != nil {
-			log.Printf("ERROR: unable to publish audit event %v : %s", evt, err.Error())
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rec).Error; err != nil {
+			return err
+		}
+		outboxRec := newOutboxEvent(&evt)
+		outboxRec.AuditID = &rec.ID
+		return tx.Create(outboxRec).Error
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to store audit event for work %s:%s field %s; send directly: %s", nameSpace, workID, auditEvt.FieldName, err.Error())
+		if sendErr := svc.sendBusEvent(&evt); sendErr != nil {
+			log.Printf("ERROR: unable to publish audit event %v : %s", evt, sendErr.Error())
 		}
+		return
 	}
+	svc.notifyDispatcher()
 }
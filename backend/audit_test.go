@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+)
+
+func TestParseAuditDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     string
+		want     time.Time
+		dateOnly bool
+		wantErr  bool
+	}{
+		{name: "rfc3339 utc", date: "2025-01-02T03:04:05Z", want: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "rfc3339 offset", date: "2025-01-02T03:04:05-05:00", want: time.Date(2025, 1, 2, 8, 4, 5, 0, time.UTC)},
+		{name: "date only", date: "2025-01-02", want: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), dateOnly: true},
+		{name: "no timezone", date: "2025-01-02T03:04:05", wantErr: true},
+		{name: "us date", date: "01/02/2025", wantErr: true},
+		{name: "blank", date: "", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, dateOnly, err := parseAuditDate(tc.date)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseAuditDate(%q) = %s; want error", tc.date, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAuditDate(%q) failed: %s", tc.date, err.Error())
+			}
+			if got.Equal(tc.want) == false || dateOnly != tc.dateOnly {
+				t.Errorf("parseAuditDate(%q) = %s, %t; want %s, %t", tc.date, got, dateOnly, tc.want, tc.dateOnly)
+			}
+		})
+	}
+}
+
+func TestAuditFilterMatches(t *testing.T) {
+	eventTime := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	before := eventTime.Add(-time.Hour)
+	after := eventTime.Add(time.Hour)
+	rec := audit{Who: "mst3k", ImpersonatedBy: "admin1", FieldName: "title", EventTime: eventTime}
+
+	if (auditFilter{}).matches(rec) == false {
+		t.Errorf("empty filter rejected %+v", rec)
+	}
+	if (auditFilter{field: "title", user: "mst3k", from: &before, to: &after}).matches(rec) == false {
+		t.Errorf("matching filter rejected %+v", rec)
+	}
+	if (auditFilter{user: "admin1"}).matches(rec) == false {
+		t.Errorf("impersonating admin filter rejected %+v", rec)
+	}
+	if (auditFilter{field: "abstract"}).matches(rec) {
+		t.Errorf("field filter accepted %+v", rec)
+	}
+	if (auditFilter{user: "abc1d"}).matches(rec) {
+		t.Errorf("user filter accepted %+v", rec)
+	}
+	if (auditFilter{from: &after}).matches(rec) {
+		t.Errorf("from filter accepted an earlier audit")
+	}
+	// the to date is exclusive
+	if (auditFilter{to: &eventTime}).matches(rec) {
+		t.Errorf("to filter accepted an audit at the end of the range")
+	}
+}
+
+func TestDeferredAuditContext(t *testing.T) {
+	svc := serviceContext{}
+	auditCtx := svc.newAuditContext(auditActor{computeID: "mst3k"}, "libraetd", "abc").deferred()
+	svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: "mst3k", FieldName: "title", Before: "old", After: "new"})
+	svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: "mst3k", FieldName: "abstract", Before: "", After: "text"})
+
+	// nothing is stored before commit; the service has no database so storing would panic
+	if len(auditCtx.pending.events) != 2 {
+		t.Fatalf("deferred context collected %d audits; want 2", len(auditCtx.pending.events))
+	}
+	if auditCtx.pending.events[0].FieldName != "title" || auditCtx.pending.events[1].FieldName != "abstract" {
+		t.Errorf("deferred audits out of order: %+v", auditCtx.pending.events)
+	}
+	copied := auditCtx
+	if copied.pending != auditCtx.pending || copied.changeID != auditCtx.changeID {
+		t.Errorf("copies of a deferred context must share its pending audits and change id")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditChangeSet groups the audits recorded by one save of a work
+type auditChangeSet struct {
+	ChangeID       string        `json:"changeID,omitempty"`
+	Who            string        `json:"who"`
+	ImpersonatedBy string        `json:"impersonatedBy,omitempty"`
+	EventTime      time.Time     `json:"eventTime"`
+	Changes        []auditChange `json:"changes"`
+}
+
+// auditChange is a single audited change with a readable label. Long text changes include a word
+// diff and file changes include the file operations that were performed.
+type auditChange struct {
+	FieldName string          `json:"fieldName"`
+	Label     string          `json:"label"`
+	Before    string          `json:"before"`
+	After     string          `json:"after"`
+	Diff      []textDiffPart  `json:"diff,omitempty"`
+	Files     []fileOperation `json:"files,omitempty"`
+}
+
+type textDiffPart struct {
+	Op   string `json:"op"` // equal, insert or delete
+	Text string `json:"text"`
+}
+
+type fileOperation struct {
+	Op      string `json:"op"` // added, removed, renamed or replaced
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+}
+
+// readable labels for metadata attributes and easystore fields
+var auditLabels = map[string]string{
+	"Title":                      "Title",
+	"Author":                     "Author",
+	"Advisors":                   "Advisor",
+	"Abstract":                   "Abstract",
+	"License":                    "License",
+	"LicenseURL":                 "License URL",
+	"Keywords":                   "Keywords",
+	"Language":                   "Language",
+	"RelatedURLs":                "Related Links",
+	"Sponsors":                   "Sponsors",
+	"Notes":                      "Notes",
+	"AdminNotes":                 "Admin Notes",
+	"Program":                    "Program",
+	"Degree":                     "Degree",
+	"ComputeID":                  "Computing ID",
+	"FirstName":                  "First Name",
+	"LastName":                   "Last Name",
+	"Department":                 "Department",
+	"Institution":                "Institution",
+	"ORCID":                      "ORCID",
+	"default-visibility":         "Visibility",
+	"embargo-release":            "Limited Access End Date",
+	"embargo-release-visibility": "Visibility After End Date",
+	"draft":                      "Draft",
+	"doi":                        "DOI",
+	"publish-date":               "Publication Date",
+	"files":                      "Files",
+	"depositor":                  "Depositor",
+}
+
+// text fields that get a word diff regardless of length; other text gets one once it is long enough
+var wordDiffFields = []string{"Abstract", "Notes", "AdminNotes"}
+
+const wordDiffMinLength = 100
+
+// limit on words compared by the diff; longer values are shown as a full replacement
+const wordDiffMaxWords = 3000
+
+var auditFieldPattern = regexp.MustCompile(`^(\w+)(?:\[(\d+)\])?(?:\.(\w+))?$`)
+
+// groupAudits converts audits ordered by time into change sets. Audits that share a change id are
+// grouped together; audits without one are each their own change set.
+func groupAudits(audits []audit) []auditChangeSet {
+	out := make([]auditChangeSet, 0)
+	setIdx := make(map[string]int)
+	for _, a := range audits {
+		change := renderAuditChange(a.FieldName, a.Before, a.After)
+		if a.ChangeID != "" {
+			if idx, found := setIdx[a.ChangeID]; found {
+				out[idx].Changes = append(out[idx].Changes, change)
+				continue
+			}
+			setIdx[a.ChangeID] = len(out)
+		}
+		out = append(out, auditChangeSet{ChangeID: a.ChangeID, Who: a.Who, ImpersonatedBy: a.ImpersonatedBy, EventTime: a.EventTime, Changes: []auditChange{change}})
+	}
+	return out
+}
+
+func renderAuditChange(fieldName, before, after string) auditChange {
+	change := auditChange{FieldName: fieldName, Label: auditLabel(fieldName), Before: before, After: after}
+	if fieldName == "files" {
+		change.Files = fileOperations(before, after)
+		return change
+	}
+	base := fieldName
+	if m := auditFieldPattern.FindStringSubmatch(fieldName); m != nil {
+		base = m[1]
+	}
+	if slices.Contains(wordDiffFields, base) || len(before) >= wordDiffMinLength || len(after) >= wordDiffMinLength {
+		change.Diff = wordDiff(before, after)
+	}
+	return change
+}
+
+// auditLabel converts an audit field name like Advisors[1].Department to Advisor 2 Department
+func auditLabel(fieldName string) string {
+	m := auditFieldPattern.FindStringSubmatch(fieldName)
+	if m == nil {
+		return labelFor(fieldName)
+	}
+	label := labelFor(m[1])
+	if m[2] != "" {
+		idx, _ := strconv.Atoi(m[2])
+		label = fmt.Sprintf("%s %d", label, idx+1)
+	}
+	if m[3] != "" {
+		label = fmt.Sprintf("%s %s", label, labelFor(m[3]))
+	}
+	return label
+}
+
+func labelFor(name string) string {
+	if label, found := auditLabels[name]; found {
+		return label
+	}
+	words := strings.Split(name, "-")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// fileOperations describes a files audit as explicit operations. File audits record the file list
+// before the change and either the file list after it or a rename / replace description.
+func fileOperations(before, after string) []fileOperation {
+	ops := make([]fileOperation, 0)
+	origNames := splitFileList(before)
+	if strings.HasPrefix(after, "Rename file ") {
+		detail := strings.TrimPrefix(after, "Rename file ")
+		// prefer a name from the original list so names containing " to " are split correctly
+		for _, name := range origNames {
+			if strings.HasPrefix(detail, name+" to ") {
+				return append(ops, fileOperation{Op: "renamed", Name: name, NewName: strings.TrimPrefix(detail, name+" to ")})
+			}
+		}
+		if parts := strings.SplitN(detail, " to ", 2); len(parts) == 2 {
+			ops = append(ops, fileOperation{Op: "renamed", Name: parts[0], NewName: parts[1]})
+		}
+		return ops
+	}
+	if strings.HasPrefix(after, "Replace file ") {
+		return append(ops, fileOperation{Op: "replaced", Name: strings.TrimPrefix(after, "Replace file ")})
+	}
+
+	newNames := splitFileList(after)
+	for _, name := range origNames {
+		if slices.Contains(newNames, name) == false {
+			ops = append(ops, fileOperation{Op: "removed", Name: name})
+		}
+	}
+	for _, name := range newNames {
+		if slices.Contains(origNames, name) == false {
+			ops = append(ops, fileOperation{Op: "added", Name: name})
+		}
+	}
+	return ops
+}
+
+func splitFileList(list string) []string {
+	out := make([]string, 0)
+	for _, name := range strings.Split(list, ", ") {
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// wordDiff returns the word level differences between two values using a longest common subsequence
+func wordDiff(before, after string) []textDiffPart {
+	orig := strings.Fields(before)
+	upd := strings.Fields(after)
+	if len(orig) > wordDiffMaxWords || len(upd) > wordDiffMaxWords {
+		return appendDiffPart(appendDiffPart(nil, "delete", before), "insert", after)
+	}
+
+	// lcs[i][j] is the length of the common subsequence of orig[i:] and upd[j:]
+	lcs := make([][]int, len(orig)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(upd)+1)
+	}
+	for i := len(orig) - 1; i >= 0; i-- {
+		for j := len(upd) - 1; j >= 0; j-- {
+			if orig[i] == upd[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var parts []textDiffPart
+	i, j := 0, 0
+	for i < len(orig) && j < len(upd) {
+		switch {
+		case orig[i] == upd[j]:
+			parts = appendDiffPart(parts, "equal", orig[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			parts = appendDiffPart(parts, "delete", orig[i])
+			i++
+		default:
+			parts = appendDiffPart(parts, "insert", upd[j])
+			j++
+		}
+	}
+	for ; i < len(orig); i++ {
+		parts = appendDiffPart(parts, "delete", orig[i])
+	}
+	for ; j < len(upd); j++ {
+		parts = appendDiffPart(parts, "insert", upd[j])
+	}
+	return parts
+}
+
+// appendDiffPart adds text to the diff, merging it with the last part when the operation is the same
+func appendDiffPart(parts []textDiffPart, op string, text string) []textDiffPart {
+	if text == "" {
+		return parts
+	}
+	if len(parts) > 0 && parts[len(parts)-1].Op == op {
+		parts[len(parts)-1].Text += " " + text
+		return parts
+	}
+	return append(parts, textDiffPart{Op: op, Text: text})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordDiff(t *testing.T) {
+	longText := strings.Repeat("word ", wordDiffMaxWords+1)
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []textDiffPart
+	}{
+		{name: "unchanged", before: "the quick fox", after: "the quick fox",
+			want: []textDiffPart{{Op: "equal", Text: "the quick fox"}}},
+		{name: "word replaced", before: "the quick fox", after: "the slow fox",
+			want: []textDiffPart{{Op: "equal", Text: "the"}, {Op: "delete", Text: "quick"}, {Op: "insert", Text: "slow"}, {Op: "equal", Text: "fox"}}},
+		{name: "words appended", before: "the fox", after: "the fox jumped over",
+			want: []textDiffPart{{Op: "equal", Text: "the fox"}, {Op: "insert", Text: "jumped over"}}},
+		{name: "words removed", before: "a very long title", after: "a title",
+			want: []textDiffPart{{Op: "equal", Text: "a"}, {Op: "delete", Text: "very long"}, {Op: "equal", Text: "title"}}},
+		{name: "whitespace changes are ignored", before: "a  b\nc", after: "a b c",
+			want: []textDiffPart{{Op: "equal", Text: "a b c"}}},
+		{name: "from blank", before: "", after: "new text",
+			want: []textDiffPart{{Op: "insert", Text: "new text"}}},
+		{name: "to blank", before: "old text", after: "",
+			want: []textDiffPart{{Op: "delete", Text: "old text"}}},
+		{name: "both blank", before: "", after: "", want: nil},
+		{name: "too long to diff", before: longText, after: "short",
+			want: []textDiffPart{{Op: "delete", Text: longText}, {Op: "insert", Text: "short"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := wordDiff(tc.before, tc.after)
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("wordDiff(%q, %q) = %+v; want %+v", tc.before, tc.after, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFileOperations(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []fileOperation
+	}{
+		{name: "file added", before: "a.pdf", after: "a.pdf, b.pdf",
+			want: []fileOperation{{Op: "added", Name: "b.pdf"}}},
+		{name: "first file added", before: "", after: "a.pdf",
+			want: []fileOperation{{Op: "added", Name: "a.pdf"}}},
+		{name: "file removed", before: "a.pdf, b.pdf", after: "b.pdf",
+			want: []fileOperation{{Op: "removed", Name: "a.pdf"}}},
+		{name: "removals listed before additions", before: "a.pdf", after: "b.pdf",
+			want: []fileOperation{{Op: "removed", Name: "a.pdf"}, {Op: "added", Name: "b.pdf"}}},
+		{name: "renamed", before: "a.pdf, b.pdf", after: "Rename file a.pdf to c.pdf",
+			want: []fileOperation{{Op: "renamed", Name: "a.pdf", NewName: "c.pdf"}}},
+		{name: "renamed file with to in the name", before: "back to school.pdf", after: "Rename file back to school.pdf to school.pdf",
+			want: []fileOperation{{Op: "renamed", Name: "back to school.pdf", NewName: "school.pdf"}}},
+		{name: "renamed file not in the list", before: "", after: "Rename file a.pdf to c.pdf",
+			want: []fileOperation{{Op: "renamed", Name: "a.pdf", NewName: "c.pdf"}}},
+		{name: "replaced", before: "a.pdf", after: "Replace file a.pdf",
+			want: []fileOperation{{Op: "replaced", Name: "a.pdf"}}},
+		{name: "no change", before: "a.pdf", after: "a.pdf", want: []fileOperation{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := fileOperations(tc.before, tc.after)
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("fileOperations(%q, %q) = %+v; want %+v", tc.before, tc.after, got, tc.want)
+			}
+		})
+	}
+}
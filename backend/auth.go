@@ -25,6 +25,11 @@ func (c *jwtClaims) isRegistrar() bool {
 	return c.Role == "registrar"
 }
 
+// auditActor returns the identity recorded in audits of changes made with these claims
+func (c *jwtClaims) auditActor() auditActor {
+	return auditActor{computeID: c.ComputeID, impersonatedBy: c.ImpersonatedBy}
+}
+
 // UserDetails contains a user response from the user-ws
 type UserDetails struct {
 	ComputeID   string   `json:"cid"`
@@ -42,6 +47,8 @@ type UserDetails struct {
 	Email       string   `json:"email"`
 	Private     string   `json:"private"`
 	Role        string   `json:"role"`
+	// set in tokens minted for an admin impersonating this user
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
 }
 
 type userServiceResp struct {
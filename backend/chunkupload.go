@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+	"gorm.io/gorm"
+)
+
+// uploadSession tracks a resumable upload. Chunks are appended to a staging file on disk
+// and the file is only added to easystore once all bytes are received and the checksum verified.
+type uploadSession struct {
+	ID        string    `json:"id"`
+	WorkID    string    `json:"workID"`
+	ComputeID string    `json:"computeID"`
+	FileName  string    `json:"fileName"`
+	TotalSize int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	Received  int64     `json:"received"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Writer    string    `json:"-"`
+}
+
+// a chunk claim that has not been committed in this time belongs to a stalled request and can be taken over
+const chunkClaimTimeout = 30 * time.Minute
+
+var errChunkOffset = errors.New("chunk offset does not match the bytes received")
+
+func (svc *serviceContext) chunkStagingPath(sessionID string) string {
+	dir := svc.Uploads.stagingDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("libra-chunked-%s", sessionID))
+}
+
+func (svc *serviceContext) initUpload(c *gin.Context) {
+	workID := c.Param("id")
+	var initReq struct {
+		FileName string `json:"fileName"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"` // hex encoded sha256 of the complete file
+	}
+	if err := c.ShouldBindJSON(&initReq); err != nil {
+		log.Printf("ERROR: bad payload in upload init request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	initReq.FileName = filepath.Base(strings.TrimSpace(initReq.FileName))
+	initReq.Checksum = strings.ToLower(strings.TrimSpace(initReq.Checksum))
+	if initReq.FileName == "" || initReq.FileName == "." || initReq.Size <= 0 {
+		c.String(http.StatusBadRequest, "fileName and size are required")
+		return
+	}
+	if _, err := hex.DecodeString(initReq.Checksum); err != nil || len(initReq.Checksum) != 64 {
+		c.String(http.StatusBadRequest, "checksum must be a hex encoded sha256 digest")
+		return
+	}
+	if initReq.Size > svc.maxFileBytes() {
+		tooBig := fileTooLargeError(initReq.FileName, svc.maxFileBytes())
+		c.String(tooBig.StatusCode, tooBig.Message)
+		return
+	}
+
+	// NOTE: this call has already been thru user middleware, so claims will be present
+	claims := getJWTClaims(c)
+	log.Printf("INFO: %s requests resumable upload of %s (%d bytes) to work %s", claims.ComputeID, initReq.FileName, initReq.Size, workID)
+	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
+	if err != nil {
+		log.Printf("ERROR: get work %s for resumable upload failed: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if svc.canEditWork(claims, esObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to upload to work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "you do not have permission to upload files to this work")
+		return
+	}
+	if isTrashed(esObj) {
+		log.Printf("INFO: reject upload to deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+	if sizeErr := svc.checkWorkSize(esObj, initReq.Size, ""); sizeErr != nil {
+		log.Printf("INFO: reject resumable upload %s to work %s: %s", initReq.FileName, workID, sizeErr.Message)
+		c.String(sizeErr.StatusCode, sizeErr.Message)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("ERROR: unable to generate upload session id: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	session := uploadSession{
+		ID:        hex.EncodeToString(idBytes),
+		WorkID:    workID,
+		ComputeID: claims.ComputeID,
+		FileName:  initReq.FileName,
+		TotalSize: initReq.Size,
+		Checksum:  initReq.Checksum,
+	}
+
+	stagingFile, err := os.Create(svc.chunkStagingPath(session.ID))
+	if err != nil {
+		log.Printf("ERROR: unable to create staging file for upload session %s: %s", session.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	stagingFile.Close()
+
+	if err := svc.DB.Create(&session).Error; err != nil {
+		log.Printf("ERROR: unable to create upload session for %s in work %s: %s", initReq.FileName, workID, err.Error())
+		os.Remove(svc.chunkStagingPath(session.ID))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("INFO: created upload session %s for %s in work %s", session.ID, session.FileName, workID)
+	c.JSON(http.StatusCreated, session)
+}
+
+func (svc *serviceContext) getUploadStatus(c *gin.Context) {
+	session, reqErr := svc.getUploadSession(c)
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// uploadChunk appends the raw request body to the staged file. The Upload-Offset header must match
+// the number of bytes already received; on mismatch a 409 with the current session is returned so
+// the client can resume from the correct position.
+func (svc *serviceContext) uploadChunk(c *gin.Context) {
+	session, reqErr := svc.getUploadSession(c)
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "a valid Upload-Offset header is required")
+		return
+	}
+
+	stagingFile, err := os.OpenFile(svc.chunkStagingPath(session.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("ERROR: unable to open staging file for upload session %s: %s", session.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer stagingFile.Close()
+	if _, err := stagingFile.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("ERROR: unable to seek staging file for upload session %s: %s", session.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the session is claimed for this chunk with a short update, so no transaction or row lock is held while the
+	// chunk is read from a slow client. a concurrent chunk for the same offset fails the claim, or the offset
+	// check once this chunk is committed, instead of writing over the bytes of this one
+	writer, claimErr := svc.claimUploadSession(session, offset)
+	if errors.Is(claimErr, errChunkOffset) {
+		log.Printf("INFO: upload session %s chunk offset %d does not match received %d", session.ID, offset, session.Received)
+		c.JSON(http.StatusConflict, session)
+		return
+	}
+	if claimErr != nil {
+		log.Printf("ERROR: unable to claim upload session %s: %s", session.ID, claimErr.Error())
+		c.String(http.StatusInternalServerError, claimErr.Error())
+		return
+	}
+	if writer == "" {
+		log.Printf("INFO: upload session %s already has a chunk in progress", session.ID)
+		c.String(http.StatusConflict, "another chunk is being written to this upload")
+		return
+	}
+
+	// a dropped connection still leaves a valid partial chunk; record what was received so the client can resume
+	remaining := session.TotalSize - session.Received
+	written, copyErr := writeChunk(stagingFile, c.Request.Body, remaining)
+	if written == remaining && copyErr != nil {
+		stagingFile.Truncate(session.TotalSize)
+	}
+
+	resp := svc.DB.Model(&uploadSession{}).Where("id=? and writer=?", session.ID, writer).
+		Updates(map[string]any{"received": gorm.Expr("received + ?", written), "writer": "", "updated_at": time.Now()})
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to record chunk for upload session %s: %s", session.ID, resp.Error.Error())
+		c.String(http.StatusInternalServerError, resp.Error.Error())
+		return
+	}
+	if resp.RowsAffected == 0 {
+		log.Printf("INFO: chunk claim for upload session %s timed out and was taken over", session.ID)
+		c.String(http.StatusConflict, "the chunk took too long and was replaced by another request")
+		return
+	}
+	session.Received += written
+
+	if copyErr != nil {
+		log.Printf("INFO: upload session %s chunk incomplete after %d bytes: %s", session.ID, written, copyErr.Error())
+		status := http.StatusBadRequest
+		if written == remaining {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.String(status, copyErr.Error())
+		return
+	}
+
+	log.Printf("INFO: upload session %s received %d of %d bytes", session.ID, session.Received, session.TotalSize)
+	c.Header("Upload-Offset", fmt.Sprintf("%d", session.Received))
+	c.JSON(http.StatusOK, session)
+}
+
+// claimUploadSession marks the session as being written by a new chunk request if the chunk offset matches the
+// bytes received and no other chunk is in progress. The claim token is returned; it is blank when another chunk
+// holds the session. errChunkOffset is returned, and the session reloaded, on an offset mismatch.
+func (svc *serviceContext) claimUploadSession(session *uploadSession, offset int64) (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	writer := hex.EncodeToString(tokenBytes)
+	now := time.Now()
+	resp := svc.DB.Model(&uploadSession{}).
+		Where("id=? and received=? and (writer=? or updated_at<?)", session.ID, offset, "", now.Add(-chunkClaimTimeout)).
+		Updates(map[string]any{"writer": writer, "updated_at": now})
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+	if resp.RowsAffected == 1 {
+		return writer, nil
+	}
+	if err := svc.DB.Where("id=?", session.ID).First(session).Error; err != nil {
+		return "", err
+	}
+	if session.Received != offset {
+		return "", errChunkOffset
+	}
+	return "", nil
+}
+
+// writeChunk copies at most remaining bytes from src to the staging file and returns the number of bytes written.
+// An error is returned with the count if the chunk is incomplete or larger than remaining.
+func writeChunk(stagingFile *os.File, src io.Reader, remaining int64) (int64, error) {
+	written, err := io.Copy(stagingFile, io.LimitReader(src, remaining+1))
+	if written > remaining {
+		return remaining, fmt.Errorf("chunk is larger than the %d bytes remaining in the declared file size", remaining)
+	}
+	return written, err
+}
+
+func (svc *serviceContext) completeUpload(c *gin.Context) {
+	session, reqErr := svc.getUploadSession(c)
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	if session.Received != session.TotalSize {
+		log.Printf("INFO: upload session %s is incomplete; %d of %d bytes received", session.ID, session.Received, session.TotalSize)
+		c.JSON(http.StatusConflict, session)
+		return
+	}
+
+	upload := stagedUpload{fileName: session.FileName, size: session.TotalSize, path: svc.chunkStagingPath(session.ID)}
+	checksum, err := fileChecksum(upload.path)
+	if err != nil {
+		log.Printf("ERROR: unable to compute checksum for upload session %s: %s", session.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if checksum != session.Checksum {
+		log.Printf("INFO: upload session %s checksum mismatch; expected %s got %s", session.ID, session.Checksum, checksum)
+		svc.removeUploadSession(session)
+		c.String(http.StatusUnprocessableEntity, fmt.Sprintf("checksum mismatch for %s; the upload must be restarted", session.FileName))
+		return
+	}
+	if err := upload.sniffMimeType(); err != nil {
+		log.Printf("ERROR: unable to read staged upload %s: %s", session.FileName, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if typeErr := svc.checkMimeType(c, &upload); typeErr != nil {
+		log.Printf("INFO: reject upload %s to work %s: %s", session.FileName, session.WorkID, typeErr.Message)
+		svc.removeUploadSession(session)
+		c.String(typeErr.StatusCode, typeErr.Message)
+		return
+	}
+
+	// access and trash state are checked again; either may have changed since the upload was started
+	claims := getJWTClaims(c)
+	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, session.WorkID, uvaeasystore.Fields|uvaeasystore.Files)
+	if err != nil {
+		log.Printf("ERROR: get work %s for file add failed: %s", session.WorkID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if svc.canEditWork(claims, esObj) == false {
+		log.Printf("INFO: %s can no longer upload to work %s; remove upload session %s", claims.ComputeID, session.WorkID, session.ID)
+		svc.removeUploadSession(session)
+		c.String(http.StatusForbidden, "you do not have permission to upload files to this work")
+		return
+	}
+	if isTrashed(esObj) {
+		log.Printf("INFO: work %s was deleted; remove upload session %s", session.WorkID, session.ID)
+		svc.removeUploadSession(session)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", session.WorkID))
+		return
+	}
+	if sizeErr := svc.checkWorkSize(esObj, upload.size, ""); sizeErr != nil {
+		log.Printf("INFO: reject upload %s to work %s: %s", session.FileName, session.WorkID, sizeErr.Message)
+		svc.removeUploadSession(session)
+		c.String(sizeErr.StatusCode, sizeErr.Message)
+		return
+	}
+
+	if err := svc.clearApprovalForFileChange(claims, esObj); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: create easystore file blob for %s with size %d and mime type %s", upload.fileName, upload.size, upload.mimeType)
+	err = svc.storeUpload(&upload, upload.fileName, func(esBlob uvaeasystore.EasyStoreBlob) error {
+		return svc.EasyStore.FileCreate(esObj.Namespace(), esObj.Id(), esBlob)
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to add %s to easystore: %s", upload.fileName, err.Error())
+		c.String(http.StatusInternalServerError, fmt.Sprintf("add %s failed: %s", upload.fileName, err.Error()))
+		return
+	}
+
+	svc.auditFileAdd(claims.auditActor(), esObj, upload.fileName)
+	svc.removeUploadSession(session)
+
+	resp := librametadata.FileData{
+		Name:      upload.fileName,
+		MimeType:  upload.mimeType,
+		CreatedAt: time.Now(),
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (svc *serviceContext) cancelUpload(c *gin.Context) {
+	session, reqErr := svc.getUploadSession(c)
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	log.Printf("INFO: cancel upload session %s for %s in work %s", session.ID, session.FileName, session.WorkID)
+	svc.removeUploadSession(session)
+	c.String(http.StatusOK, "canceled")
+}
+
+// getUploadSession loads the session named in the request and ensures it belongs to the requesting user
+func (svc *serviceContext) getUploadSession(c *gin.Context) (*uploadSession, *RequestError) {
+	claims := getJWTClaims(c)
+	if claims == nil {
+		return nil, &RequestError{StatusCode: http.StatusUnauthorized, Message: "sign in is required"}
+	}
+
+	var session uploadSession
+	err := svc.DB.Where("id=? and work_id=?", c.Param("uid"), c.Param("id")).First(&session).Error
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("upload %s was not found", c.Param("uid"))}
+		}
+		log.Printf("ERROR: unable to load upload session %s: %s", c.Param("uid"), err.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	if session.ComputeID != claims.ComputeID && claims.isAdmin() == false {
+		log.Printf("INFO: unauthorized attempt by %s to access upload session %s", claims.ComputeID, session.ID)
+		return nil, &RequestError{StatusCode: http.StatusForbidden, Message: "you do not have permission to access this upload"}
+	}
+	return &session, nil
+}
+
+func (svc *serviceContext) removeUploadSession(session *uploadSession) {
+	if err := os.Remove(svc.chunkStagingPath(session.ID)); err != nil && errors.Is(err, os.ErrNotExist) == false {
+		log.Printf("WARNING: unable to remove staging file for upload session %s: %s", session.ID, err.Error())
+	}
+	if err := svc.DB.Delete(session).Error; err != nil {
+		log.Printf("ERROR: unable to delete upload session %s: %s", session.ID, err.Error())
+	}
+}
+
+// startUploadCleanup periodically removes upload sessions that have not received data within the expire window
+func (svc *serviceContext) startUploadCleanup(expire time.Duration) {
+	log.Printf("INFO: start cleanup of upload sessions idle for more than %s", expire.String())
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for {
+			var abandoned []uploadSession
+			if err := svc.DB.Where("updated_at < ?", time.Now().Add(-expire)).Find(&abandoned).Error; err != nil {
+				log.Printf("ERROR: unable to find abandoned upload sessions: %s", err.Error())
+			}
+			for _, session := range abandoned {
+				log.Printf("INFO: remove abandoned upload session %s for %s in work %s", session.ID, session.FileName, session.WorkID)
+				svc.removeUploadSession(&session)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteChunk(t *testing.T) {
+	stagingFile, err := os.Create(filepath.Join(t.TempDir(), "chunk"))
+	if err != nil {
+		t.Fatalf("unable to create staging file: %s", err.Error())
+	}
+	defer stagingFile.Close()
+
+	written, err := writeChunk(stagingFile, strings.NewReader("hello"), 10)
+	if err != nil || written != 5 {
+		t.Fatalf("writeChunk of a partial file = %d, %v; want 5, nil", written, err)
+	}
+	written, err = writeChunk(stagingFile, strings.NewReader("world"), 5)
+	if err != nil || written != 5 {
+		t.Fatalf("writeChunk of the final chunk = %d, %v; want 5, nil", written, err)
+	}
+	if staged, _ := os.ReadFile(stagingFile.Name()); string(staged) != "helloworld" {
+		t.Errorf("staged file = %q; want helloworld", staged)
+	}
+
+	written, err = writeChunk(stagingFile, strings.NewReader("extra bytes"), 5)
+	if err == nil || written != 5 {
+		t.Errorf("writeChunk of an oversize chunk = %d, %v; want 5 and an error", written, err)
+	}
+}
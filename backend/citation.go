@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	strip "github.com/grokify/html-strip-tags-go"
+)
+
+const defaultInstitution = "University of Virginia"
+
+// citationFormat describes a downloadable citation record format
+type citationFormat struct {
+	Label       string
+	ContentType string
+	Extension   string
+}
+
+var citationFormats = map[string]citationFormat{
+	"bibtex":  {Label: "BibTeX", ContentType: "application/x-bibtex", Extension: "bib"},
+	"ris":     {Label: "RIS", ContentType: "application/x-research-info-systems", Extension: "ris"},
+	"csljson": {Label: "CSL-JSON", ContentType: "application/vnd.citationstyles.csl+json", Extension: "json"},
+	"endnote": {Label: "EndNote", ContentType: "application/x-endnote-refer", Extension: "enw"},
+}
+
+// rendered citation styles, in the order they are shown on the public view page
+var citationStyles = []struct {
+	Key   string
+	Label string
+}{
+	{Key: "apa", Label: "APA"},
+	{Key: "mla", Label: "MLA"},
+	{Key: "chicago", Label: "Chicago"},
+}
+
+type citationName struct {
+	FirstName string
+	LastName  string
+}
+
+// citation contains the work data needed to generate citation records and rendered styles
+type citation struct {
+	WorkID      string
+	Title       string
+	Author      citationName
+	Advisors    []citationName
+	Degree      string
+	Program     string
+	Institution string
+	Published   *time.Time
+	DOI         string
+	URL         string
+	Abstract    string
+	Keywords    []string
+	Language    string
+}
+
+func (svc *serviceContext) getCitation(c *gin.Context) {
+	workID := c.Param("id")
+	format := strings.ToLower(c.Query("format"))
+	log.Printf("INFO: request %s citation for work %s", format, workID)
+
+	etdWork, err := svc.getWork(c, workID, "cite")
+	if err != nil {
+		if err.StatusCode == 404 {
+			log.Printf("INFO: work %s was not found", workID)
+		} else {
+			log.Printf("ERROR: get work %s for citation failed: %d - %s", workID, err.StatusCode, err.Message)
+		}
+		c.String(err.StatusCode, err.Message)
+		return
+	}
+	cite := svc.newCitation(etdWork)
+
+	if fmtInfo, ok := citationFormats[format]; ok {
+		var out string
+		switch format {
+		case "bibtex":
+			out = cite.bibtex()
+		case "ris":
+			out = cite.ris()
+		case "csljson":
+			out = cite.cslJSON()
+		case "endnote":
+			out = cite.endnote()
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", workID, fmtInfo.Extension))
+		c.Data(http.StatusOK, fmt.Sprintf("%s; charset=utf-8", fmtInfo.ContentType), []byte(out))
+		return
+	}
+
+	for _, style := range citationStyles {
+		if style.Key == format {
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(cite.render(format, false)))
+			return
+		}
+	}
+
+	supported := []string{"bibtex", "ris", "csljson", "endnote"}
+	for _, style := range citationStyles {
+		supported = append(supported, style.Key)
+	}
+	log.Printf("INFO: unsupported citation format [%s] requested for work %s", format, workID)
+	c.String(http.StatusBadRequest, fmt.Sprintf("format must be one of: %s", strings.Join(supported, ", ")))
+}
+
+func (svc *serviceContext) newCitation(work *workDetails) *citation {
+	cite := citation{
+		WorkID:      work.ID,
+		Title:       strings.TrimSpace(strip.StripTags(work.Title)),
+		Author:      citationName{FirstName: strings.TrimSpace(work.Author.FirstName), LastName: strings.TrimSpace(work.Author.LastName)},
+		Degree:      work.Degree,
+		Program:     work.Program,
+		Institution: work.Author.Institution,
+		DOI:         doiFromField(work.PersistentLink),
+		URL:         work.PersistentLink,
+		Abstract:    work.Abstract,
+		Keywords:    work.Keywords,
+		Language:    work.Language,
+	}
+	if cite.Institution == "" {
+		cite.Institution = defaultInstitution
+	}
+	if cite.URL == "" {
+		cite.URL = fmt.Sprintf("%s/public_view/%s", svc.EtdURL, work.ID)
+	}
+	for _, adv := range work.Advisors {
+		if adv.LastName != "" && adv.FirstName != "" {
+			cite.Advisors = append(cite.Advisors, citationName{FirstName: adv.FirstName, LastName: adv.LastName})
+		}
+	}
+	if work.PublishedAt != "" {
+		pubDate, err := time.Parse(svc.TimeFormat, work.PublishedAt)
+		if err != nil {
+			log.Printf("WARNING: unable to parse work %s published date %s: %s", work.ID, work.PublishedAt, err.Error())
+		} else {
+			cite.Published = &pubDate
+		}
+	}
+	return &cite
+}
+
+// isMasters is true for master's theses and false for doctoral dissertations
+func (cite *citation) isMasters() bool {
+	return strings.Contains(strings.ToLower(cite.Degree), "master")
+}
+
+func (cite *citation) year() string {
+	if cite.Published == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", cite.Published.Year())
+}
+
+func (cite *citation) advisorNames() []string {
+	out := make([]string, 0)
+	for _, adv := range cite.Advisors {
+		out = append(out, fmt.Sprintf("%s, %s", adv.LastName, adv.FirstName))
+	}
+	return out
+}
+
+var bibtexKeyRegex = regexp.MustCompile(`[^a-z0-9]`)
+
+func (cite *citation) bibtex() string {
+	entryType := "phdthesis"
+	if cite.isMasters() {
+		entryType = "mastersthesis"
+	}
+	titleWords := strings.Fields(strings.ToLower(cite.Title))
+	firstWord := ""
+	if len(titleWords) > 0 {
+		firstWord = titleWords[0]
+	}
+	key := bibtexKeyRegex.ReplaceAllString(strings.ToLower(cite.Author.LastName)+cite.year()+firstWord, "")
+	if key == "" {
+		key = cite.WorkID
+	}
+
+	fields := [][2]string{
+		{"author", fmt.Sprintf("%s, %s", cite.Author.LastName, cite.Author.FirstName)},
+		{"title", cite.Title},
+		{"school", cite.Institution},
+		{"type", cite.Degree},
+		{"year", cite.year()},
+	}
+	if cite.Published != nil {
+		fields = append(fields, [2]string{"month", strings.ToLower(cite.Published.Format("Jan"))})
+	}
+	fields = append(fields, [2]string{"doi", cite.DOI}, [2]string{"url", cite.URL})
+	if len(cite.Advisors) > 0 {
+		fields = append(fields, [2]string{"note", fmt.Sprintf("Advisors: %s", strings.Join(cite.advisorNames(), "; "))})
+	}
+	fields = append(fields, [2]string{"keywords", strings.Join(cite.Keywords, ", ")},
+		[2]string{"language", cite.Language}, [2]string{"abstract", cite.Abstract})
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "@%s{%s,\n", entryType, key)
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		// url and doi are read verbatim by bibtex styles; escaping them would corrupt the link
+		value := f[1]
+		if f[0] != "url" && f[0] != "doi" {
+			value = bibtexEscape(value)
+		}
+		if f[0] == "title" {
+			// double braces preserve title capitalization
+			value = fmt.Sprintf("{%s}", value)
+		}
+		fmt.Fprintf(&out, "  %s = {%s},\n", f[0], value)
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+var bibtexEscaper = strings.NewReplacer(`\`, `\textbackslash{}`, `{`, `\{`, `}`, `\}`, `&`, `\&`, `%`, `\%`, `$`, `\$`, `#`, `\#`, `_`, `\_`)
+
+func bibtexEscape(val string) string {
+	return bibtexEscaper.Replace(val)
+}
+
+func (cite *citation) ris() string {
+	var out strings.Builder
+	add := func(tag, val string) {
+		val = strings.Join(strings.Fields(val), " ")
+		if val != "" {
+			fmt.Fprintf(&out, "%s  - %s\r\n", tag, val)
+		}
+	}
+	add("TY", "THES")
+	add("AU", fmt.Sprintf("%s, %s", cite.Author.LastName, cite.Author.FirstName))
+	for _, adv := range cite.advisorNames() {
+		add("A2", adv)
+	}
+	add("TI", cite.Title)
+	add("PY", cite.year())
+	if cite.Published != nil {
+		add("DA", cite.Published.Format("2006/01/02"))
+	}
+	add("PB", cite.Institution)
+	add("M3", cite.Degree)
+	add("DO", cite.DOI)
+	add("UR", cite.URL)
+	add("AB", cite.Abstract)
+	for _, kw := range cite.Keywords {
+		add("KW", kw)
+	}
+	add("LA", cite.Language)
+	out.WriteString("ER  - \r\n")
+	return out.String()
+}
+
+func (cite *citation) endnote() string {
+	var out strings.Builder
+	add := func(tag, val string) {
+		val = strings.Join(strings.Fields(val), " ")
+		if val != "" {
+			fmt.Fprintf(&out, "%s %s\n", tag, val)
+		}
+	}
+	add("%0", "Thesis")
+	add("%A", fmt.Sprintf("%s, %s", cite.Author.LastName, cite.Author.FirstName))
+	for _, adv := range cite.advisorNames() {
+		add("%E", adv)
+	}
+	add("%T", cite.Title)
+	add("%D", cite.year())
+	if cite.Published != nil {
+		add("%8", cite.Published.Format("2006-01-02"))
+	}
+	add("%I", cite.Institution)
+	add("%9", cite.Degree)
+	add("%R", cite.DOI)
+	add("%U", cite.URL)
+	add("%X", cite.Abstract)
+	for _, kw := range cite.Keywords {
+		add("%K", kw)
+	}
+	add("%G", cite.Language)
+	return out.String()
+}
+
+func (cite *citation) cslJSON() string {
+	type cslName struct {
+		Family string `json:"family"`
+		Given  string `json:"given"`
+	}
+	type cslDate struct {
+		DateParts [][]int `json:"date-parts"`
+	}
+	type cslItem struct {
+		ID          string    `json:"id"`
+		Type        string    `json:"type"`
+		Title       string    `json:"title"`
+		Author      []cslName `json:"author"`
+		Contributor []cslName `json:"contributor,omitempty"`
+		Issued      *cslDate  `json:"issued,omitempty"`
+		Publisher   string    `json:"publisher"`
+		Genre       string    `json:"genre,omitempty"`
+		Abstract    string    `json:"abstract,omitempty"`
+		Keyword     string    `json:"keyword,omitempty"`
+		Language    string    `json:"language,omitempty"`
+		DOI         string    `json:"DOI,omitempty"`
+		URL         string    `json:"URL,omitempty"`
+	}
+	item := cslItem{
+		ID:        cite.WorkID,
+		Type:      "thesis",
+		Title:     cite.Title,
+		Author:    []cslName{{Family: cite.Author.LastName, Given: cite.Author.FirstName}},
+		Publisher: cite.Institution,
+		Genre:     cite.Degree,
+		Abstract:  cite.Abstract,
+		Keyword:   strings.Join(cite.Keywords, ", "),
+		Language:  cite.Language,
+		DOI:       cite.DOI,
+		URL:       cite.URL,
+	}
+	for _, adv := range cite.Advisors {
+		item.Contributor = append(item.Contributor, cslName{Family: adv.LastName, Given: adv.FirstName})
+	}
+	if cite.Published != nil {
+		item.Issued = &cslDate{DateParts: [][]int{{cite.Published.Year(), int(cite.Published.Month()), cite.Published.Day()}}}
+	}
+	out, _ := json.MarshalIndent([]cslItem{item}, "", "  ")
+	return string(out)
+}
+
+// suggested is the libra house citation:
+// [Author LastName], [Author FirstName]. [Title]. [Author Institution], [program], [Degree], [Published Year], [DOI URI].
+func (cite *citation) suggested() string {
+	pubDate := ""
+	if cite.Published != nil {
+		pubDate = cite.Published.Format("2006-01-02")
+	}
+	doiURI := ""
+	if cite.DOI != "" {
+		doiURI = fmt.Sprintf("%s%s", doiResolverURL, cite.DOI)
+	}
+	return fmt.Sprintf("%s, %s. %s. %s, %s, %s, %s, %s.", cite.Author.LastName, cite.Author.FirstName,
+		cite.Title, cite.Institution, cite.Program, cite.Degree, pubDate, doiURI)
+}
+
+// render generates a citation in one of the supported styles. When asHTML is set the result is escaped
+// and titles are italicized; otherwise it is plain text.
+func (cite *citation) render(style string, asHTML bool) string {
+	esc := func(val string) string {
+		if asHTML {
+			return template.HTMLEscapeString(val)
+		}
+		return val
+	}
+	italic := func(val string) string {
+		if asHTML {
+			return fmt.Sprintf("<em>%s</em>", template.HTMLEscapeString(val))
+		}
+		return val
+	}
+	link := cite.URL
+	if cite.DOI != "" {
+		link = fmt.Sprintf("%s%s", doiResolverURL, cite.DOI)
+	}
+	year := cite.year()
+	title := strings.TrimSuffix(cite.Title, ".")
+
+	switch style {
+	case "apa":
+		// Last, F. M. (Year). Title [Doctoral dissertation, Institution]. Link
+		initials := make([]string, 0)
+		for _, name := range strings.Fields(cite.Author.FirstName) {
+			initials = append(initials, fmt.Sprintf("%s.", string([]rune(name)[0])))
+		}
+		if year == "" {
+			year = "n.d."
+		}
+		kind := "Doctoral dissertation"
+		if cite.isMasters() {
+			kind = "Master's thesis"
+		}
+		return fmt.Sprintf("%s (%s). %s [%s]. %s", esc(fmt.Sprintf("%s, %s", cite.Author.LastName, strings.Join(initials, " "))),
+			esc(year), italic(title), esc(fmt.Sprintf("%s, %s", kind, cite.Institution)), esc(link))
+	case "mla":
+		// Last, First. Title. Year. Institution, Degree. Link.
+		parts := []string{esc(fmt.Sprintf("%s, %s.", cite.Author.LastName, cite.Author.FirstName)), italic(title) + "."}
+		if year != "" {
+			parts = append(parts, esc(year)+".")
+		}
+		parts = append(parts, esc(fmt.Sprintf("%s, %s.", cite.Institution, cite.Degree)), esc(link)+".")
+		return strings.Join(parts, " ")
+	case "chicago":
+		// Last, First. "Title." PhD diss./Master's thesis, Institution, Year. Link.
+		kind := "PhD diss."
+		if cite.isMasters() {
+			kind = "Master's thesis"
+		}
+		where := fmt.Sprintf("%s, %s", kind, cite.Institution)
+		if year != "" {
+			where = fmt.Sprintf("%s, %s", where, year)
+		}
+		return fmt.Sprintf("%s “%s.” %s. %s.", esc(fmt.Sprintf("%s, %s.", cite.Author.LastName, cite.Author.FirstName)),
+			esc(title), esc(where), esc(link))
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testCitations() (*citation, *citation) {
+	published := time.Date(2024, 5, 17, 14, 0, 0, 0, time.UTC)
+	doctoral := citation{
+		WorkID:      "abc",
+		Title:       "A Study of Things",
+		Author:      citationName{FirstName: "Mary Ann", LastName: "Smith"},
+		Advisors:    []citationName{{FirstName: "John", LastName: "Doe"}},
+		Degree:      "PHD (Doctor of Philosophy)",
+		Program:     "History",
+		Institution: "University of Virginia",
+		Published:   &published,
+		DOI:         "10.18130/xyz",
+		URL:         "https://doi.org/10.18130/xyz",
+		Abstract:    "An\nabstract",
+		Keywords:    []string{"history", "things"},
+		Language:    "English",
+	}
+	masters := citation{
+		WorkID:      "def",
+		Title:       "Fish & Chips",
+		Author:      citationName{FirstName: "Tom", LastName: "Jones"},
+		Degree:      "MA (Master of Arts)",
+		Program:     "English",
+		Institution: "University of Virginia",
+		URL:         "https://libra.example.edu/public_view/def",
+	}
+	return &doctoral, &masters
+}
+
+func TestCitationRecords(t *testing.T) {
+	doctoral, masters := testCitations()
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "bibtex doctoral", got: doctoral.bibtex(),
+			want: "@phdthesis{smith2024a,\n" +
+				"  author = {Smith, Mary Ann},\n" +
+				"  title = {{A Study of Things}},\n" +
+				"  school = {University of Virginia},\n" +
+				"  type = {PHD (Doctor of Philosophy)},\n" +
+				"  year = {2024},\n" +
+				"  month = {may},\n" +
+				"  doi = {10.18130/xyz},\n" +
+				"  url = {https://doi.org/10.18130/xyz},\n" +
+				"  note = {Advisors: Doe, John},\n" +
+				"  keywords = {history, things},\n" +
+				"  language = {English},\n" +
+				"  abstract = {An\nabstract},\n" +
+				"}\n"},
+		{name: "bibtex masters escapes and skips blanks", got: masters.bibtex(),
+			want: "@mastersthesis{jonesfish,\n" +
+				"  author = {Jones, Tom},\n" +
+				"  title = {{Fish \\& Chips}},\n" +
+				"  school = {University of Virginia},\n" +
+				"  type = {MA (Master of Arts)},\n" +
+				"  url = {https://libra.example.edu/public_view/def},\n" +
+				"}\n"},
+		{name: "ris doctoral", got: doctoral.ris(),
+			want: "TY  - THES\r\nAU  - Smith, Mary Ann\r\nA2  - Doe, John\r\nTI  - A Study of Things\r\nPY  - 2024\r\n" +
+				"DA  - 2024/05/17\r\nPB  - University of Virginia\r\nM3  - PHD (Doctor of Philosophy)\r\nDO  - 10.18130/xyz\r\n" +
+				"UR  - https://doi.org/10.18130/xyz\r\nAB  - An abstract\r\nKW  - history\r\nKW  - things\r\nLA  - English\r\nER  - \r\n"},
+		{name: "ris masters", got: masters.ris(),
+			want: "TY  - THES\r\nAU  - Jones, Tom\r\nTI  - Fish & Chips\r\nPB  - University of Virginia\r\nM3  - MA (Master of Arts)\r\n" +
+				"UR  - https://libra.example.edu/public_view/def\r\nER  - \r\n"},
+		{name: "endnote doctoral", got: doctoral.endnote(),
+			want: "%0 Thesis\n%A Smith, Mary Ann\n%E Doe, John\n%T A Study of Things\n%D 2024\n%8 2024-05-17\n" +
+				"%I University of Virginia\n%9 PHD (Doctor of Philosophy)\n%R 10.18130/xyz\n%U https://doi.org/10.18130/xyz\n" +
+				"%X An abstract\n%K history\n%K things\n%G English\n"},
+		{name: "endnote masters", got: masters.endnote(),
+			want: "%0 Thesis\n%A Jones, Tom\n%T Fish & Chips\n%I University of Virginia\n%9 MA (Master of Arts)\n" +
+				"%U https://libra.example.edu/public_view/def\n"},
+		{name: "suggested", got: doctoral.suggested(),
+			want: "Smith, Mary Ann. A Study of Things. University of Virginia, History, PHD (Doctor of Philosophy), 2024-05-17, https://doi.org/10.18130/xyz."},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("got:\n%q\nwant:\n%q", tc.got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCitationCSLJSON(t *testing.T) {
+	doctoral, masters := testCitations()
+	tests := []struct {
+		name string
+		cite *citation
+		want string
+	}{
+		{name: "doctoral", cite: doctoral,
+			want: `[{"id":"abc","type":"thesis","title":"A Study of Things","author":[{"family":"Smith","given":"Mary Ann"}],
+				"contributor":[{"family":"Doe","given":"John"}],"issued":{"date-parts":[[2024,5,17]]},
+				"publisher":"University of Virginia","genre":"PHD (Doctor of Philosophy)","abstract":"An\nabstract",
+				"keyword":"history, things","language":"English","DOI":"10.18130/xyz","URL":"https://doi.org/10.18130/xyz"}]`},
+		{name: "masters", cite: masters,
+			want: `[{"id":"def","type":"thesis","title":"Fish & Chips","author":[{"family":"Jones","given":"Tom"}],
+				"publisher":"University of Virginia","genre":"MA (Master of Arts)","URL":"https://libra.example.edu/public_view/def"}]`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got, want any
+			if err := json.Unmarshal([]byte(tc.cite.cslJSON()), &got); err != nil {
+				t.Fatalf("cslJSON is not valid json: %s", err.Error())
+			}
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatalf("invalid expected json: %s", err.Error())
+			}
+			if reflect.DeepEqual(got, want) == false {
+				t.Errorf("cslJSON = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCitationRender(t *testing.T) {
+	doctoral, masters := testCitations()
+	tests := []struct {
+		name   string
+		cite   *citation
+		style  string
+		asHTML bool
+		want   string
+	}{
+		{name: "apa doctoral", cite: doctoral, style: "apa",
+			want: "Smith, M. A. (2024). A Study of Things [Doctoral dissertation, University of Virginia]. https://doi.org/10.18130/xyz"},
+		{name: "apa doctoral html", cite: doctoral, style: "apa", asHTML: true,
+			want: "Smith, M. A. (2024). <em>A Study of Things</em> [Doctoral dissertation, University of Virginia]. https://doi.org/10.18130/xyz"},
+		{name: "apa masters without date", cite: masters, style: "apa",
+			want: "Jones, T. (n.d.). Fish & Chips [Master's thesis, University of Virginia]. https://libra.example.edu/public_view/def"},
+		{name: "apa masters html is escaped", cite: masters, style: "apa", asHTML: true,
+			want: "Jones, T. (n.d.). <em>Fish &amp; Chips</em> [Master&#39;s thesis, University of Virginia]. https://libra.example.edu/public_view/def"},
+		{name: "mla doctoral", cite: doctoral, style: "mla",
+			want: "Smith, Mary Ann. A Study of Things. 2024. University of Virginia, PHD (Doctor of Philosophy). https://doi.org/10.18130/xyz."},
+		{name: "mla masters without date", cite: masters, style: "mla",
+			want: "Jones, Tom. Fish & Chips. University of Virginia, MA (Master of Arts). https://libra.example.edu/public_view/def."},
+		{name: "chicago doctoral", cite: doctoral, style: "chicago",
+			want: "Smith, Mary Ann. “A Study of Things.” PhD diss., University of Virginia, 2024. https://doi.org/10.18130/xyz."},
+		{name: "chicago masters without date", cite: masters, style: "chicago",
+			want: "Jones, Tom. “Fish & Chips.” Master's thesis, University of Virginia. https://libra.example.edu/public_view/def."},
+		{name: "unknown style", cite: doctoral, style: "harvard", want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.cite.render(tc.style, tc.asHTML); got != tc.want {
+				t.Errorf("render(%s, %t) =\n%q\nwant\n%q", tc.style, tc.asHTML, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm/clause"
+)
+
+// collaborator permissions; view allows an advisor to review a draft, edit allows a delegated co-editor
+// to change metadata and files. Only the depositor or an admin can publish.
+const (
+	collaboratorView = "view"
+	collaboratorEdit = "edit"
+)
+
+type workCollaborator struct {
+	ID         uint64    `json:"-"`
+	WorkID     string    `json:"-"`
+	ComputeID  string    `json:"computeID"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	AddedBy    string    `json:"addedBy"`
+	AddedAt    time.Time `json:"addedAt"`
+}
+
+type collaboratorRequest struct {
+	ComputeID  string `json:"computeID"`
+	Permission string `json:"permission"`
+}
+
+var collaboratorNotice = notification{Template: "collaborator.txt", Subject: "You have been invited to a thesis in Libra"}
+
+// getCollaborator returns the collaborator record for a user on a work, or nil if there is none
+func (svc *serviceContext) getCollaborator(workID string, computeID string) *workCollaborator {
+	var rec workCollaborator
+	resp := svc.DB.Where("work_id=? and compute_id=?", workID, computeID).Limit(1).Find(&rec)
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to get collaborator %s for work %s: %s", computeID, workID, resp.Error.Error())
+		return nil
+	}
+	if resp.RowsAffected == 0 {
+		return nil
+	}
+	return &rec
+}
+
+// canEditWork returns true if the signed in user is the depositor, an admin, or an edit collaborator
+func (svc *serviceContext) canEditWork(claims *jwtClaims, tgtObj uvaeasystore.EasyStoreObject) bool {
+	return claims.isAdmin() || svc.isWorkEditor(claims.ComputeID, tgtObj)
+}
+
+// isWorkEditor returns true if the user is the depositor or an edit collaborator of a work
+func (svc *serviceContext) isWorkEditor(computeID string, tgtObj uvaeasystore.EasyStoreObject) bool {
+	if computeID == tgtObj.Fields()["depositor"] {
+		return true
+	}
+	collab := svc.getCollaborator(tgtObj.Id(), computeID)
+	return collab != nil && collab.Permission == collaboratorEdit
+}
+
+// isWorkMember returns true if the signed in user is the depositor, an admin, or a collaborator of any permission
+func (svc *serviceContext) isWorkMember(claims *jwtClaims, tgtObj uvaeasystore.EasyStoreObject) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.ComputeID == tgtObj.Fields()["depositor"] || claims.isAdmin() {
+		return true
+	}
+	return svc.getCollaborator(tgtObj.Id(), claims.ComputeID) != nil
+}
+
+func auditCollaborator(computeID string, before, after *workCollaborator) uvalibrabus.UvaAuditEvent {
+	auditEvt := uvalibrabus.UvaAuditEvent{Who: computeID, FieldName: "collaborator"}
+	if before != nil {
+		auditEvt.Before = fmt.Sprintf("%s:%s", before.ComputeID, before.Permission)
+	}
+	if after != nil {
+		auditEvt.After = fmt.Sprintf("%s:%s", after.ComputeID, after.Permission)
+	}
+	return auditEvt
+}
+
+// getCollaboratorWork loads the work for the ID param and verifies the signed in user may view its
+// collaborators. The response has already been sent and nil is returned if not.
+func (svc *serviceContext) getCollaboratorWork(c *gin.Context) uvaeasystore.EasyStoreObject {
+	workID := c.Param("id")
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for collaborators: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return nil
+	}
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: work %s is in the trash; collaborators are not available", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return nil
+	}
+	return tgtObj
+}
+
+func (svc *serviceContext) getCollaborators(c *gin.Context) {
+	claims := getJWTClaims(c)
+	if claims == nil {
+		log.Printf("INFO: reject anonymous request for collaborators of work %s", c.Param("id"))
+		c.String(http.StatusUnauthorized, "sign in is required")
+		return
+	}
+	tgtObj := svc.getCollaboratorWork(c)
+	if tgtObj == nil {
+		return
+	}
+	workID := tgtObj.Id()
+	if svc.isWorkMember(claims, tgtObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to get collaborators for work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, fmt.Sprintf("access to %s is not authorized", workID))
+		return
+	}
+
+	collabs := make([]workCollaborator, 0)
+	if err := svc.DB.Where("work_id=?", workID).Order("added_at asc").Find(&collabs).Error; err != nil {
+		log.Printf("ERROR: unable to get collaborators for work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, collabs)
+}
+
+// addCollaborator adds a collaborator to a work or changes the permission of an existing collaborator.
+// New collaborators are sent an email with a link to the work.
+func (svc *serviceContext) addCollaborator(c *gin.Context) {
+	claims := getJWTClaims(c)
+	var req collaboratorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("ERROR: bad payload in add collaborator request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	req.ComputeID = strings.ToLower(strings.TrimSpace(req.ComputeID))
+	if req.ComputeID == "" {
+		c.String(http.StatusBadRequest, "compute id is required")
+		return
+	}
+	if req.Permission != collaboratorView && req.Permission != collaboratorEdit {
+		c.String(http.StatusBadRequest, fmt.Sprintf("permission must be %s or %s", collaboratorView, collaboratorEdit))
+		return
+	}
+
+	tgtObj := svc.getCollaboratorWork(c)
+	if tgtObj == nil {
+		return
+	}
+	workID := tgtObj.Id()
+	depositor := tgtObj.Fields()["depositor"]
+	log.Printf("INFO: %s requests %s access to work %s for %s", claims.ComputeID, req.Permission, workID, req.ComputeID)
+	if (claims.ComputeID == depositor || claims.isAdmin()) == false {
+		log.Printf("INFO: unauthorized attempt by %s to add collaborator to work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "only the depositor or an admin can add collaborators")
+		return
+	}
+	if req.ComputeID == depositor {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is the depositor of this work", req.ComputeID))
+		return
+	}
+
+	if err := svc.Protected.refreshJWT(svc.JWTKey); err != nil {
+		log.Printf("ERROR: unable to refresh protected services jwt: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, userErr := svc.getUserDetails(req.ComputeID)
+	if userErr != nil {
+		log.Printf("INFO: unable to add collaborator %s to work %s: %s", req.ComputeID, workID, userErr.Message)
+		c.String(userErr.StatusCode, userErr.Message)
+		return
+	}
+
+	prior := svc.getCollaborator(workID, req.ComputeID)
+	if prior != nil && prior.Permission == req.Permission {
+		c.JSON(http.StatusOK, prior)
+		return
+	}
+	collab := workCollaborator{
+		WorkID:     workID,
+		ComputeID:  req.ComputeID,
+		Name:       strings.TrimSpace(fmt.Sprintf("%s %s", user.FirstName, user.LastName)),
+		Email:      user.Email,
+		Permission: req.Permission,
+		AddedBy:    claims.ComputeID,
+		AddedAt:    time.Now(),
+	}
+	if prior != nil {
+		collab.AddedAt = prior.AddedAt
+	}
+	err := svc.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "work_id"}, {Name: "compute_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "email", "permission", "added_by"}),
+	}).Create(&collab).Error
+	if err != nil {
+		log.Printf("ERROR: unable to save collaborator %s for work %s: %s", req.ComputeID, workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.publishAuditEvent(claims.auditActor(), svc.Namespace, workID, auditCollaborator(claims.ComputeID, prior, &collab))
+
+	if prior == nil {
+		work, err := svc.parseWork(tgtObj, false)
+		if err != nil {
+			log.Printf("ERROR: unable to parse work %s for collaborator invitation: %s", workID, err.Error())
+		} else {
+			invite := svc.newEmailData(work)
+			invite.Permission = req.Permission
+			recipient := collab.Email
+			if recipient == "" {
+				recipient = fmt.Sprintf("%s@virginia.edu", collab.ComputeID)
+			}
+			if err := svc.sendNotification(claims.auditActor(), workID, collaboratorNotice, recipient, invite); err != nil {
+				log.Printf("ERROR: unable to send collaborator invitation for work %s: %s", workID, err.Error())
+			}
+		}
+	}
+	c.JSON(http.StatusOK, collab)
+}
+
+// removeCollaborator removes a collaborator from a work. Collaborators may remove themselves.
+func (svc *serviceContext) removeCollaborator(c *gin.Context) {
+	claims := getJWTClaims(c)
+	computeID := strings.ToLower(c.Param("cid"))
+	tgtObj := svc.getCollaboratorWork(c)
+	if tgtObj == nil {
+		return
+	}
+	workID := tgtObj.Id()
+	log.Printf("INFO: %s requests removal of collaborator %s from work %s", claims.ComputeID, computeID, workID)
+	if (claims.ComputeID == tgtObj.Fields()["depositor"] || claims.isAdmin() || claims.ComputeID == computeID) == false {
+		log.Printf("INFO: unauthorized attempt by %s to remove collaborator from work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "only the depositor or an admin can remove collaborators")
+		return
+	}
+
+	prior := svc.getCollaborator(workID, computeID)
+	if prior == nil {
+		c.String(http.StatusNotFound, fmt.Sprintf("%s is not a collaborator on %s", computeID, workID))
+		return
+	}
+	if err := svc.DB.Delete(prior).Error; err != nil {
+		log.Printf("ERROR: unable to remove collaborator %s from work %s: %s", computeID, workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.publishAuditEvent(claims.auditActor(), svc.Namespace, workID, auditCollaborator(claims.ComputeID, prior, nil))
+	c.String(http.StatusOK, "ok")
+}
@@ -6,9 +6,10 @@ import (
 )
 
 type devConfig struct {
-	user    string
-	role    string
-	fakeBus bool
+	user     string
+	role     string
+	fakeBus  bool
+	emailDir string
 }
 
 type orcidConfig struct {
@@ -16,6 +17,30 @@ type orcidConfig struct {
 	clientURL  string
 }
 
+type uploadConfig struct {
+	maxFileMB    int64
+	maxWorkMB    int64
+	maxTransfers int
+	stagingDir   string
+	expireHours  int
+}
+
+type dataciteConfig struct {
+	url    string
+	user   string
+	pass   string
+	prefix string
+}
+
+type emailConfig struct {
+	host          string
+	port          int
+	user          string
+	pass          string
+	sender        string
+	embargoNotice int
+}
+
 type dbConfig struct {
 	host string
 	port int
@@ -39,6 +64,11 @@ type configData struct {
 	busName         string
 	eventSourceName string
 	indexURL        string
+	embargoInterval int
+	trashRetention  int
+	uploads         uploadConfig
+	datacite        dataciteConfig
+	email           emailConfig
 	dev             devConfig
 }
 
@@ -69,6 +99,7 @@ func getConfiguration() *configData {
 	flag.StringVar(&config.dev.user, "devuser", "", "Authorized computing id for dev")
 	flag.StringVar(&config.dev.role, "devrole", "user", "Role for dev user")
 	flag.BoolVar(&config.dev.fakeBus, "devbus", false, "bus dev mode (no events sent out)")
+	flag.StringVar(&config.dev.emailDir, "devemaildir", "", "Directory to write email to when no smtp host is configured (default log only)")
 
 	// easystore cfg
 	flag.StringVar(&config.easyStoreProxy, "esproxy", "", "EasyStore proxy")
@@ -79,6 +110,33 @@ func getConfiguration() *configData {
 	// search index
 	flag.StringVar(&config.indexURL, "index", "", "Search index URL")
 
+	// file uploads
+	// uploads are not streamed to easystore; each file is held fully in memory while it is stored, so peak
+	// upload memory is maxtransfers * maxfilesize
+	flag.Int64Var(&config.uploads.maxFileMB, "maxfilesize", 1024, "Max size in MB of a single uploaded file")
+	flag.Int64Var(&config.uploads.maxWorkMB, "maxworksize", 10240, "Max size in MB of all files in a work")
+	flag.IntVar(&config.uploads.maxTransfers, "maxtransfers", 2, "Max uploads sent to easystore at the same time")
+	flag.StringVar(&config.uploads.stagingDir, "uploaddir", "", "Directory for staging uploads (default system temp dir)")
+	flag.IntVar(&config.uploads.expireHours, "uploadexpire", 24, "Hours before an idle resumable upload is removed")
+
+	// DataCite DOI registration; DOIs are not minted when the url is blank
+	flag.StringVar(&config.datacite.url, "dataciteurl", "", "DataCite API URL")
+	flag.StringVar(&config.datacite.user, "dataciteuser", "", "DataCite repository ID")
+	flag.StringVar(&config.datacite.pass, "datacitepass", "", "DataCite repository password")
+	flag.StringVar(&config.datacite.prefix, "doiprefix", "", "DOI prefix for minted DOIs")
+
+	// email notifications; messages are logged rather than sent when the smtp host is blank
+	flag.StringVar(&config.email.host, "smtphost", "", "SMTP host")
+	flag.IntVar(&config.email.port, "smtpport", 587, "SMTP port")
+	flag.StringVar(&config.email.user, "smtpuser", "", "SMTP user")
+	flag.StringVar(&config.email.pass, "smtppass", "", "SMTP password")
+	flag.StringVar(&config.email.sender, "smtpsender", "libra@virginia.edu", "Sender address for email notifications")
+	flag.IntVar(&config.email.embargoNotice, "embargonotice", 30, "Days before an embargo expires to notify the author (0 to disable)")
+
+	// background processing
+	flag.IntVar(&config.embargoInterval, "embargointerval", 60, "Minutes between expired embargo checks (0 to disable)")
+	flag.IntVar(&config.trashRetention, "trashretention", 30, "Days deleted works remain in the trash before they are purged (0 to disable purge)")
+
 	// event bus
 	flag.StringVar(&config.busName, "busname", "", "Event bus name")
 	flag.StringVar(&config.eventSourceName, "eventsrc", "", "Event source name")
@@ -141,11 +199,32 @@ func getConfiguration() *configData {
 	log.Printf("[CONFIG] dbhost          = [%s]", config.db.host)
 	log.Printf("[CONFIG] dbname          = [%s]", config.db.name)
 	log.Printf("[CONFIG] dbuser          = [%s]", config.db.user)
+	log.Printf("[CONFIG] embargointerval = [%d]", config.embargoInterval)
+	log.Printf("[CONFIG] trashretention  = [%d]", config.trashRetention)
+	log.Printf("[CONFIG] maxfilesize     = [%d]", config.uploads.maxFileMB)
+	log.Printf("[CONFIG] maxworksize     = [%d]", config.uploads.maxWorkMB)
+	log.Printf("[CONFIG] maxtransfers    = [%d]", config.uploads.maxTransfers)
+	log.Printf("[CONFIG] uploaddir       = [%s]", config.uploads.stagingDir)
+	log.Printf("[CONFIG] uploadexpire    = [%d]", config.uploads.expireHours)
+	log.Printf("[CONFIG] dataciteurl     = [%s]", config.datacite.url)
+	log.Printf("[CONFIG] dataciteuser    = [%s]", config.datacite.user)
+	log.Printf("[CONFIG] doiprefix       = [%s]", config.datacite.prefix)
+	log.Printf("[CONFIG] smtphost        = [%s]", config.email.host)
+	log.Printf("[CONFIG] smtpport        = [%d]", config.email.port)
+	log.Printf("[CONFIG] smtpuser        = [%s]", config.email.user)
+	log.Printf("[CONFIG] smtpsender      = [%s]", config.email.sender)
+	log.Printf("[CONFIG] embargonotice   = [%d]", config.email.embargoNotice)
 
 	if config.dev.user != "" {
 		log.Printf("[CONFIG] devuser         = [%s]", config.dev.user)
 		log.Printf("[CONFIG] devrole         = [%s]", config.dev.role)
 	}
+	if config.email.host == "" {
+		log.Printf("[CONFIG] ** dev mode email - notifications are not sent **")
+		if config.dev.emailDir != "" {
+			log.Printf("[CONFIG] devemaildir     = [%s]", config.dev.emailDir)
+		}
+	}
 	if config.dev.fakeBus {
 		log.Printf("[CONFIG] ** dev mode bus - event publishing is disabled **")
 	}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+)
+
+// DataCite DOI state change events. A DOI in the findable state is discoverable in DataCite search; hiding it
+// moves it back to the registered state. Registered DOIs can never be deleted, so hidden DOIs act as tombstones.
+const (
+	doiEventPublish = "publish"
+	doiEventHide    = "hide"
+)
+
+const doiResolverURL = "https://doi.org/"
+
+type dataciteNameIdentifier struct {
+	NameIdentifier       string `json:"nameIdentifier"`
+	NameIdentifierScheme string `json:"nameIdentifierScheme"`
+	SchemeURI            string `json:"schemeUri"`
+}
+
+type dataciteAffiliation struct {
+	Name string `json:"name"`
+}
+
+type dataciteCreator struct {
+	Name            string                   `json:"name"`
+	NameType        string                   `json:"nameType"`
+	GivenName       string                   `json:"givenName,omitempty"`
+	FamilyName      string                   `json:"familyName,omitempty"`
+	NameIdentifiers []dataciteNameIdentifier `json:"nameIdentifiers,omitempty"`
+	Affiliation     []dataciteAffiliation    `json:"affiliation,omitempty"`
+	ContributorType string                   `json:"contributorType,omitempty"`
+}
+
+type dataciteAttributes struct {
+	Prefix          string              `json:"prefix,omitempty"`
+	Event           string              `json:"event,omitempty"`
+	URL             string              `json:"url,omitempty"`
+	Creators        []dataciteCreator   `json:"creators,omitempty"`
+	Contributors    []dataciteCreator   `json:"contributors,omitempty"`
+	Titles          []map[string]string `json:"titles,omitempty"`
+	Publisher       string              `json:"publisher,omitempty"`
+	PublicationYear int                 `json:"publicationYear,omitempty"`
+	Types           map[string]string   `json:"types,omitempty"`
+	Descriptions    []map[string]string `json:"descriptions,omitempty"`
+	Subjects        []map[string]string `json:"subjects,omitempty"`
+	RightsList      []map[string]string `json:"rightsList,omitempty"`
+	FundingRefs     []map[string]string `json:"fundingReferences,omitempty"`
+	Language        string              `json:"language,omitempty"`
+}
+
+type dataciteRequest struct {
+	Data struct {
+		ID         string             `json:"id,omitempty"`
+		Type       string             `json:"type"`
+		Attributes dataciteAttributes `json:"attributes"`
+	} `json:"data"`
+}
+
+// doiFromField extracts the bare DOI from the doi field, which may be stored as a resolver URL or with a doi: prefix
+func doiFromField(fieldValue string) string {
+	doi := strings.TrimSpace(fieldValue)
+	doi = strings.TrimPrefix(doi, doiResolverURL)
+	doi = strings.TrimPrefix(doi, "http://dx.doi.org/")
+	doi = strings.TrimPrefix(doi, "doi:")
+	return doi
+}
+
+func (svc *serviceContext) dataciteEnabled() bool {
+	return svc.DataCite.url != ""
+}
+
+// registerDOI makes the DOI for a work findable in DataCite, minting a new DOI if the work does not already
+// have one. The returned value is the resolver URL that is stored in the doi field. The work must include
+// the fields and metadata components.
+func (svc *serviceContext) registerDOI(tgtObj uvaeasystore.EasyStoreObject) (string, error) {
+	if svc.dataciteEnabled() == false {
+		log.Printf("INFO: datacite is not configured; skip doi registration for work %s", tgtObj.Id())
+		return tgtObj.Fields()["doi"], nil
+	}
+
+	pl, err := svc.dataciteMetadata(tgtObj)
+	if err != nil {
+		return "", err
+	}
+	pl.Data.Attributes.Event = doiEventPublish
+
+	doi := doiFromField(tgtObj.Fields()["doi"])
+	if doi == "" {
+		log.Printf("INFO: mint new doi for work %s", tgtObj.Id())
+		pl.Data.Attributes.Prefix = svc.DataCite.prefix
+		doi, err = svc.sendDataCiteRequest("POST", "dois", pl)
+	} else {
+		log.Printf("INFO: register existing doi %s for work %s", doi, tgtObj.Id())
+		doi, err = svc.sendDataCiteRequest("PUT", fmt.Sprintf("dois/%s", doi), pl)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("INFO: work %s doi %s is findable", tgtObj.Id(), doi)
+	return fmt.Sprintf("%s%s", doiResolverURL, doi), nil
+}
+
+// registerWorkDOI registers the DOI of a work once its publication has been saved, so a findable DOI
+// never points at a draft. A newly minted DOI is saved to the doi field of the work. A DOI failure does
+// not block publication; the DOI will be registered on the next update of the work. The saved DOI of the
+// work is returned, or blank if it has none.
+func (svc *serviceContext) registerWorkDOI(actor auditActor, workID string) string {
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for doi registration: %s", workID, err.Error())
+		return ""
+	}
+	doi, err := svc.registerDOI(tgtObj)
+	if err != nil {
+		log.Printf("ERROR: unable to register doi for work %s: %s", workID, err.Error())
+		return ""
+	}
+	fields := tgtObj.Fields()
+	priorDOI := fields["doi"]
+	if doi == priorDOI {
+		return doi
+	}
+	fields["doi"] = doi
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		log.Printf("ERROR: doi %s was registered for work %s but could not be saved: %s", doi, workID, err.Error())
+		return ""
+	}
+	auditEvt := uvalibrabus.UvaAuditEvent{
+		Who:       actor.computeID,
+		FieldName: "doi",
+		Before:    priorDOI,
+		After:     doi,
+	}
+	svc.publishAuditEvent(actor, svc.Namespace, workID, auditEvt)
+	return doi
+}
+
+// updateDOIMetadata pushes the current work metadata to DataCite without changing the DOI state.
+// The work must include the fields and metadata components.
+func (svc *serviceContext) updateDOIMetadata(tgtObj uvaeasystore.EasyStoreObject) error {
+	doi := doiFromField(tgtObj.Fields()["doi"])
+	if svc.dataciteEnabled() == false || doi == "" {
+		return nil
+	}
+	pl, err := svc.dataciteMetadata(tgtObj)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: update doi %s metadata for work %s", doi, tgtObj.Id())
+	_, err = svc.sendDataCiteRequest("PUT", fmt.Sprintf("dois/%s", doi), pl)
+	return err
+}
+
+// hideDOI moves a findable DOI back to the registered state. The DOI continues to resolve to the public
+// view of the work, which will show a tombstone for works that have been unpublished or deleted.
+func (svc *serviceContext) hideDOI(workID string, doiField string) error {
+	doi := doiFromField(doiField)
+	if svc.dataciteEnabled() == false || doi == "" {
+		return nil
+	}
+	log.Printf("INFO: hide doi %s for work %s", doi, workID)
+	var pl dataciteRequest
+	pl.Data.Type = "dois"
+	pl.Data.Attributes.Event = doiEventHide
+	_, err := svc.sendDataCiteRequest("PUT", fmt.Sprintf("dois/%s", doi), pl)
+	return err
+}
+
+func (svc *serviceContext) dataciteMetadata(tgtObj uvaeasystore.EasyStoreObject) (*dataciteRequest, error) {
+	if tgtObj.Metadata() == nil {
+		return nil, fmt.Errorf("work %s has no metadata", tgtObj.Id())
+	}
+	mdBytes, err := tgtObj.Metadata().Payload()
+	if err != nil {
+		return nil, err
+	}
+	etdWork, err := librametadata.ETDWorkFromBytes(mdBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := tgtObj.Fields()
+	pubYear := time.Now().Year()
+	if fields["publish-date"] != "" {
+		pubYear = parseDate(fields["publish-date"]).Year()
+	}
+
+	var pl dataciteRequest
+	pl.Data.Type = "dois"
+	attribs := &pl.Data.Attributes
+	attribs.URL = fmt.Sprintf("%s/public_view/%s", svc.EtdURL, tgtObj.Id())
+	attribs.Publisher = "University of Virginia"
+	attribs.PublicationYear = pubYear
+	attribs.Language = etdWork.Language
+	attribs.Types = map[string]string{"resourceTypeGeneral": "Dissertation", "resourceType": etdWork.Degree}
+	attribs.Titles = []map[string]string{{"title": etdWork.Title}}
+	attribs.Creators = []dataciteCreator{dataciteContributor(etdWork.Author)}
+	for _, advisor := range etdWork.Advisors {
+		if advisor.FirstName == "" && advisor.LastName == "" {
+			continue
+		}
+		contributor := dataciteContributor(advisor)
+		contributor.ContributorType = "Supervisor"
+		attribs.Contributors = append(attribs.Contributors, contributor)
+	}
+	if etdWork.Abstract != "" {
+		attribs.Descriptions = []map[string]string{{"description": etdWork.Abstract, "descriptionType": "Abstract"}}
+	}
+	for _, kw := range etdWork.Keywords {
+		attribs.Subjects = append(attribs.Subjects, map[string]string{"subject": kw})
+	}
+	if etdWork.Program != "" {
+		attribs.Subjects = append(attribs.Subjects, map[string]string{"subject": etdWork.Program})
+	}
+	if etdWork.License != "" {
+		attribs.RightsList = []map[string]string{{"rights": etdWork.License, "rightsUri": etdWork.LicenseURL}}
+	}
+	for _, sponsor := range etdWork.Sponsors {
+		attribs.FundingRefs = append(attribs.FundingRefs, map[string]string{"funderName": sponsor})
+	}
+	return &pl, nil
+}
+
+func dataciteContributor(person librametadata.ContributorData) dataciteCreator {
+	out := dataciteCreator{
+		Name:       fmt.Sprintf("%s, %s", person.LastName, person.FirstName),
+		NameType:   "Personal",
+		GivenName:  person.FirstName,
+		FamilyName: person.LastName,
+	}
+	if person.Institution != "" {
+		out.Affiliation = []dataciteAffiliation{{Name: person.Institution}}
+	}
+	if person.ORCID != "" {
+		orcid := person.ORCID
+		if strings.HasPrefix(orcid, "http") == false {
+			orcid = fmt.Sprintf("https://orcid.org/%s", orcid)
+		}
+		out.NameIdentifiers = []dataciteNameIdentifier{{NameIdentifier: orcid, NameIdentifierScheme: "ORCID", SchemeURI: "https://orcid.org"}}
+	}
+	return out
+}
+
+// sendDataCiteRequest sends a JSON:API request to the DataCite REST API and returns the DOI from the response
+func (svc *serviceContext) sendDataCiteRequest(verb string, path string, payload any) (string, error) {
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(svc.DataCite.url, "/"), path)
+	log.Printf("INFO: datacite %s request: %s", verb, url)
+	b, _ := json.Marshal(payload)
+	req, err := http.NewRequest(verb, url, bytes.NewBuffer(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+	req.Header.Set("Accept", "application/vnd.api+json")
+	if svc.DataCite.user != "" {
+		req.SetBasicAuth(svc.DataCite.user, svc.DataCite.pass)
+	}
+
+	rawResp, rawErr := svc.HTTPClient.Do(req)
+	resp, respErr := handleAPIResponse(url, rawResp, rawErr)
+	if respErr != nil {
+		return "", fmt.Errorf("datacite request failed %d: %s", respErr.StatusCode, respErr.Message)
+	}
+
+	var parsed struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &parsed); err != nil {
+		return "", fmt.Errorf("unable to parse datacite response: %s", err.Error())
+	}
+	return parsed.Data.ID, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestDoiFromField(t *testing.T) {
+	const doi = "10.18130/abc123"
+	for _, field := range []string{
+		"https://doi.org/10.18130/abc123",
+		"http://dx.doi.org/10.18130/abc123",
+		"doi:10.18130/abc123",
+		"10.18130/abc123",
+		"  https://doi.org/10.18130/abc123 \n",
+	} {
+		if got := doiFromField(field); got != doi {
+			t.Errorf("doiFromField(%q) = %q; want %q", field, got, doi)
+		}
+	}
+	if got := doiFromField(""); got != "" {
+		t.Errorf("doiFromField of a blank field = %q; want blank", got)
+	}
+}
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS embargo_releases;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS embargo_releases (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   release_date VARCHAR (30) NOT NULL,
+   prior_visibility VARCHAR (20) NOT NULL,
+   release_visibility VARCHAR (20) NOT NULL,
+   released_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS embargo_releases_work_date_idx ON embargo_releases (work_id, release_date);
+
+COMMIT;
\ No newline at end of file
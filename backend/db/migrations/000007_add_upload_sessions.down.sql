@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS upload_sessions;
+
+COMMIT;
\ No newline at end of file
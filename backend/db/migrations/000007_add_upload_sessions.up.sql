@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+   id VARCHAR (40) PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   compute_id VARCHAR (20) NOT NULL,
+   file_name VARCHAR (255) NOT NULL,
+   total_size BIGINT NOT NULL,
+   checksum VARCHAR (64) NOT NULL,
+   received BIGINT NOT NULL DEFAULT 0,
+   created_at TIMESTAMPTZ NOT NULL,
+   updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS upload_sessions_work_idx ON upload_sessions (work_id);
+
+COMMIT;
\ No newline at end of file
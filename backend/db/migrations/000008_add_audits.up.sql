@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS audits (
+   id bigserial PRIMARY KEY,
+   namespace VARCHAR (30) NOT NULL,
+   work_id VARCHAR (30) NOT NULL,
+   who VARCHAR (20) NOT NULL,
+   field_name VARCHAR (80) NOT NULL,
+   before TEXT NOT NULL DEFAULT '',
+   after TEXT NOT NULL DEFAULT '',
+   event_time TIMESTAMPTZ NOT NULL,
+   published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS audits_work_idx ON audits (work_id, event_time);
+CREATE INDEX IF NOT EXISTS audits_unpublished_idx ON audits (id) WHERE published_at IS NULL;
+
+COMMIT;
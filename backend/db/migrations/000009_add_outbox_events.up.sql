@@ -0,0 +1,20 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+   id bigserial PRIMARY KEY,
+   event_name VARCHAR (80) NOT NULL,
+   namespace VARCHAR (30) NOT NULL,
+   identifier VARCHAR (255) NOT NULL,
+   detail BYTEA,
+   audit_id bigint REFERENCES audits(id) ON DELETE SET NULL,
+   status VARCHAR (20) NOT NULL DEFAULT 'pending',
+   attempts integer NOT NULL DEFAULT 0,
+   last_error TEXT NOT NULL DEFAULT '',
+   next_attempt_at TIMESTAMPTZ NOT NULL,
+   created_at TIMESTAMPTZ NOT NULL,
+   sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_status_idx ON outbox_events (status, next_attempt_at);
+
+COMMIT;
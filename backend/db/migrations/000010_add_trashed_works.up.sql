@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS trashed_works (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   namespace VARCHAR (30) NOT NULL,
+   title TEXT NOT NULL DEFAULT '',
+   author VARCHAR (255) NOT NULL DEFAULT '',
+   depositor VARCHAR (20) NOT NULL DEFAULT '',
+   deleted_by VARCHAR (20) NOT NULL,
+   deleted_at TIMESTAMPTZ NOT NULL,
+   purge_after TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS trashed_works_work_idx ON trashed_works (work_id);
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   namespace VARCHAR (30) NOT NULL,
+   title TEXT NOT NULL DEFAULT '',
+   author VARCHAR (255) NOT NULL DEFAULT '',
+   doi VARCHAR (255) NOT NULL DEFAULT '',
+   reason TEXT NOT NULL DEFAULT '',
+   withdrawn_type VARCHAR (20) NOT NULL,
+   withdrawn_by VARCHAR (20) NOT NULL,
+   withdrawn_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawals_work_idx ON withdrawals (work_id);
+
+COMMIT;
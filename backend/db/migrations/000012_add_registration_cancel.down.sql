@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX IF EXISTS registration_students_compute_idx;
+DROP INDEX IF EXISTS registration_students_work_idx;
+
+ALTER TABLE registration_students DROP COLUMN IF EXISTS cancelled_by;
+ALTER TABLE registration_students DROP COLUMN IF EXISTS cancelled_at;
+
+COMMIT;
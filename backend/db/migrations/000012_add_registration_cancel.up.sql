@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE registration_students ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMPTZ;
+ALTER TABLE registration_students ADD COLUMN IF NOT EXISTS cancelled_by VARCHAR (20) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS registration_students_work_idx ON registration_students (work_id);
+CREATE INDEX IF NOT EXISTS registration_students_compute_idx ON registration_students (compute_id);
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS work_collaborators (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   compute_id VARCHAR (20) NOT NULL,
+   name VARCHAR (255) NOT NULL DEFAULT '',
+   email VARCHAR (255) NOT NULL DEFAULT '',
+   permission VARCHAR (10) NOT NULL,
+   added_by VARCHAR (20) NOT NULL,
+   added_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS work_collaborators_work_user_idx ON work_collaborators (work_id, compute_id);
+CREATE INDEX IF NOT EXISTS work_collaborators_compute_idx ON work_collaborators (compute_id);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS work_reviews;
+ALTER TABLE programs DROP COLUMN IF EXISTS review_required;
+
+COMMIT;
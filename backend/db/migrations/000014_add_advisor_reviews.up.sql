@@ -0,0 +1,16 @@
+BEGIN;
+
+ALTER TABLE programs ADD COLUMN IF NOT EXISTS review_required BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS work_reviews (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   compute_id VARCHAR (20) NOT NULL,
+   decision VARCHAR (20) NOT NULL,
+   comments TEXT NOT NULL DEFAULT '',
+   created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS work_reviews_work_idx ON work_reviews (work_id);
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS work_versions (
+   id serial PRIMARY KEY,
+   work_id VARCHAR (30) NOT NULL,
+   version INTEGER NOT NULL,
+   vtag VARCHAR (64) NOT NULL DEFAULT '',
+   metadata TEXT NOT NULL,
+   fields TEXT NOT NULL,
+   note VARCHAR (255) NOT NULL DEFAULT '',
+   created_by VARCHAR (20) NOT NULL,
+   created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS work_versions_work_version_idx ON work_versions (work_id, version);
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE audits DROP COLUMN IF EXISTS change_id;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- audits recorded by a single save share a change id so they can be shown as one change set
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS change_id VARCHAR (32) NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS system_audits;
+ALTER TABLE audits DROP COLUMN IF EXISTS impersonated_by;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- the admin acting as the user when a change was made with an impersonated token
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS impersonated_by VARCHAR (20) NOT NULL DEFAULT '';
+
+-- audits of actions that are not made to a work, such as impersonation and configuration changes
+CREATE TABLE IF NOT EXISTS system_audits (
+   id bigserial PRIMARY KEY,
+   who VARCHAR (20) NOT NULL,
+   impersonated_by VARCHAR (20) NOT NULL DEFAULT '',
+   action VARCHAR (80) NOT NULL,
+   target VARCHAR (255) NOT NULL DEFAULT '',
+   before TEXT NOT NULL DEFAULT '',
+   after TEXT NOT NULL DEFAULT '',
+   event_time TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS system_audits_time_idx ON system_audits (event_time);
+
+COMMIT;
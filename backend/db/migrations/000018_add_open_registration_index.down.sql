@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX IF EXISTS registration_students_open_idx;
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+
+-- students registered more than once before duplicates were rejected keep only their newest open
+-- registration. Open registrations for works that no longer exist are cancelled by the service the
+-- next time the student is registered.
+UPDATE registration_students SET cancelled_at = NOW(), cancelled_by = 'libra-web'
+   WHERE completed_at IS NULL AND cancelled_at IS NULL AND id NOT IN (
+      SELECT MAX(id) FROM registration_students WHERE completed_at IS NULL AND cancelled_at IS NULL
+      GROUP BY compute_id
+   );
+
+-- a student may only have one open registration at a time
+CREATE UNIQUE INDEX IF NOT EXISTS registration_students_open_idx ON registration_students (compute_id)
+   WHERE completed_at IS NULL AND cancelled_at IS NULL;
+
+COMMIT;
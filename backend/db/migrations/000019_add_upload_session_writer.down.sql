@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE upload_sessions DROP COLUMN IF EXISTS writer;
+
+COMMIT;
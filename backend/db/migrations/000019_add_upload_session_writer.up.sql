@@ -0,0 +1,6 @@
+BEGIN;
+
+-- the chunk request currently writing to an upload session; blank when no chunk is in progress
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS writer VARCHAR (32) NOT NULL DEFAULT '';
+
+COMMIT;
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"time"
@@ -17,23 +16,33 @@ import (
 // "author": work author, often the depositor too.
 // "create-date": timestamp, date the work was created.
 // "modify-date": timestamp, date the work was modified.
+// "deleted": timestamp, when the work was moved to the trash. Works in the trash are hidden and purged after the retention period.
+// "deleted-by": compute id of the admin that deleted the work.
 // "depositor": work depositor, not necessarily the author.
 // "doi": the work DOI/permanent resource link.
 // "disposition": indicates work disposition, currently only "imported" to reflect work imported from the existing Libra repository.
 // "draft": values "true" or "false" to indicate if a work is a draft or if it has been published
 // "embargo-release": timestamp, when embargo expires (if appropriate).
+// "embargo-notice-sent": timestamp that indicates when an "embargo expiring" email was sent. Cleared when the embargo is released.
 // "embargo-release-visibility": visibility after embargo expires (if appropriate).
 // "invitation-sent": timestamp that indicates when an "invitation to deposit" email was sent.
 // "publish-date": timestamp when an OA work goes from private to public or when a user clicks Publish on an ETD work.
 // "resource-type": type of work, libraOpen only.
+// "review-advisors": comma separated compute IDs of the advisors asked to review when the work was submitted.
+// "review-date": timestamp, when an advisor last approved or requested changes to the work.
+// "review-notice-sent": timestamp that indicates when a "ready for review" email was sent to an advisor.
+// "review-requested": timestamp, when the depositor submitted the work for advisor review.
+// "review-status": advisor review state, "pending", "approved" or "changes-requested". Required before publication in some programs.
 // "registrar": the person creating the optional deposit registration
 // "sis-sent": timestamp, indicates notification sent to SIS. LibraETD only.
 // "source-id": source of the thesis, a unique SIS or Optional identifier. LibraETD only.
 // "source": source of the thesis, "sis" or "optional".
 // "submitted-sent" timestamp that indicates when a "successfully submitted" email was sent.
+// "unpublished-sent": timestamp that indicates when a "work withdrawn" email was sent.
 // "visibility": work visibility, either "open", "uva" or "embargo".
 
 type updateSettings struct {
+	Version                  string `json:"version,omitempty"`
 	Visibility               string `json:"visibility"`
 	EmbargoReleaseDate       string `json:"embargoReleaseDate,omitempty"`
 	EmbargoReleaseVisibility string `json:"embargoReleaseVisibility,omitempty"`
@@ -44,69 +53,85 @@ type etdUpdateRequest struct {
 	updateSettings
 }
 
+type studentRequest struct {
+	ComputeID string `json:"computeID"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
 type registrationRequest struct {
-	Program  string `json:"program"`
-	Degree   string `json:"degree"`
-	Students []struct {
-		ComputeID string `json:"computeID"`
-		Email     string `json:"email"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-	} `json:"students"`
+	Program  string           `json:"program"`
+	Degree   string           `json:"degree"`
+	Students []studentRequest `json:"students"`
 }
 
 func (svc *serviceContext) uploadFile(c *gin.Context) {
 	workID := c.Param("id")
 	log.Printf("INFO: file upload request received for work %s", workID)
 
-	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Files)
+	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: get work %s for file add failed: %s", workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	mpForm, err := c.MultipartForm()
-	if err != nil {
-		log.Printf("INFO: unable to get multipart form for file upload: %s", err.Error())
-		c.String(http.StatusBadRequest, fmt.Sprintf("unable to get upload: %s", err.Error()))
+	// NOTE: this call has already been thru user or admin middleware, so claims will be present
+	claims := getJWTClaims(c)
+	if svc.canEditWork(claims, esObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to upload to work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "you do not have permission to upload files to this work")
 		return
 	}
 
-	// only 1 file can be uploaded at a time
-	formFile := mpForm.File["file"][0]
-	log.Printf("INFO: receive submission %s", formFile.Filename)
-	uploadSrc, err := formFile.Open()
-	if err != nil {
-		log.Printf("INFO: unable to open multipart form for file upload: %s", err.Error())
-		c.String(http.StatusBadRequest, fmt.Sprintf("unable to open %s: %s", formFile.Filename, err.Error()))
+	if isTrashed(esObj) {
+		log.Printf("INFO: reject file upload to deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
 		return
 	}
-	defer uploadSrc.Close()
-	uploadBytes, err := io.ReadAll(uploadSrc)
-	if err != nil {
-		log.Printf("INFO: unable to read %s: %s", formFile.Filename, err.Error())
-		c.String(http.StatusBadRequest, fmt.Sprintf("unable to read %s: %s", formFile.Filename, err.Error()))
+
+	upload, uploadErr := svc.stageUpload(c)
+	if uploadErr != nil {
+		log.Printf("INFO: unable to receive file upload for work %s: %s", workID, uploadErr.Message)
+		c.String(uploadErr.StatusCode, uploadErr.Message)
 		return
 	}
+	defer upload.remove()
 
-	mimeType := http.DetectContentType(uploadBytes)
+	if typeErr := svc.checkMimeType(c, upload); typeErr != nil {
+		log.Printf("INFO: reject upload %s to work %s: %s", upload.fileName, workID, typeErr.Message)
+		c.String(typeErr.StatusCode, typeErr.Message)
+		return
+	}
+
+	if sizeErr := svc.checkWorkSize(esObj, upload.size, ""); sizeErr != nil {
+		log.Printf("INFO: reject upload %s to work %s: %s", upload.fileName, workID, sizeErr.Message)
+		c.String(sizeErr.StatusCode, sizeErr.Message)
+		return
+	}
+
+	if err := svc.clearApprovalForFileChange(claims, esObj); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	log.Printf("INFO: create easystore file blob for %s with size %d and mime type %s",
-		formFile.Filename, len(uploadBytes), mimeType)
-	esBlob := uvaeasystore.NewEasyStoreBlob(formFile.Filename, mimeType, uploadBytes)
-	if err := svc.EasyStore.FileCreate(esObj.Namespace(), esObj.Id(), esBlob); err != nil {
-		log.Printf("ERROR: unable to add %s to easystore: %s", formFile.Filename, err.Error())
-		c.String(http.StatusInternalServerError, fmt.Sprintf("add %s failed: %s", formFile.Filename, err.Error()))
+		upload.fileName, upload.size, upload.mimeType)
+	err = svc.storeUpload(upload, upload.fileName, func(esBlob uvaeasystore.EasyStoreBlob) error {
+		return svc.EasyStore.FileCreate(esObj.Namespace(), esObj.Id(), esBlob)
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to add %s to easystore: %s", upload.fileName, err.Error())
+		c.String(http.StatusInternalServerError, fmt.Sprintf("add %s failed: %s", upload.fileName, err.Error()))
 		return
 	}
 
-	// NOTE: this call has already been thru user or admin middleware, so claims will be present
-	claims := getJWTClaims(c)
-	svc.auditFileAdd(claims.ComputeID, esObj, formFile.Filename)
+	svc.auditFileAdd(claims.auditActor(), esObj, upload.fileName)
 
 	resp := librametadata.FileData{
-		Name:      formFile.Filename,
-		MimeType:  mimeType,
+		Name:      upload.fileName,
+		MimeType:  upload.mimeType,
 		CreatedAt: time.Now(),
 	}
 
@@ -118,22 +143,39 @@ func (svc *serviceContext) deleteFile(c *gin.Context) {
 	delFileName := c.Param("name")
 	log.Printf("INFO: request to delete %s from work %s received", workID, delFileName)
 
-	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Files)
+	esObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: get work %s for file delete failed: %s", workID, err.Error())
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
+	// NOTE: this call has already been thru user or admin middleware, so claims will be present
+	claims := getJWTClaims(c)
+	if svc.canEditWork(claims, esObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to delete %s from work %s", claims.ComputeID, delFileName, workID)
+		c.String(http.StatusForbidden, "you do not have permission to delete files from this work")
+		return
+	}
+
+	if isTrashed(esObj) {
+		log.Printf("INFO: reject file delete from deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
+	if err := svc.clearApprovalForFileChange(claims, esObj); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err := svc.EasyStore.FileDelete(esObj.Namespace(), esObj.Id(), delFileName); err != nil {
 		log.Printf("ERROR: delete %s from %s failed: %s", delFileName, workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// NOTE: this call has already been thru user or admin middleware, so claims will be present
-	claims := getJWTClaims(c)
-	svc.auditFileDelete(claims.ComputeID, esObj, delFileName)
+	svc.auditFileDelete(claims.auditActor(), esObj, delFileName)
 
 	c.String(http.StatusOK, "ok")
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/uvalib/easystore/uvaeasystore"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm/clause"
+)
+
+// event published when a work visibility changes because its embargo has expired
+const eventEmbargoRelease = "work.embargo-release"
+
+// compute id recorded in audits for changes made by background processing rather than a user
+const systemComputeID = "libra-web"
+
+// embargoRelease records an embargo that has been released
+type embargoRelease struct {
+	ID                uint64
+	WorkID            string
+	ReleaseDate       string
+	PriorVisibility   string
+	ReleaseVisibility string
+	ReleasedAt        time.Time
+}
+
+// embargoDocumentResp is a Meilisearch documents/fetch response containing the embargo fields of a work
+type embargoDocumentResp struct {
+	Results []struct {
+		ID     string `json:"id"`
+		Fields struct {
+			DefaultVisibility        string `json:"default-visibility"`
+			EmbargoRelaeseDate       string `json:"embargo-release"`
+			EmbargoRelaeseVisibility string `json:"embargo-release-visibility"`
+		} `json:"fields"`
+	} `json:"results"`
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// startEmbargoProcessor runs the embargo release check immediately, then on the configured interval
+func (svc *serviceContext) startEmbargoProcessor(interval time.Duration) {
+	log.Printf("INFO: start embargo release processor with a %s interval", interval.String())
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			svc.runExclusive("embargo-processor", svc.processEmbargoReleases)
+			<-ticker.C
+		}
+	}()
+}
+
+// processEmbargoReleases finds all works with an embargo-release date in the past, makes the release
+// visibility the default visibility and clears the embargo fields. Works with an embargo that will be
+// released within the notice period are found by the same search and passed on for notices.
+func (svc *serviceContext) processEmbargoReleases() {
+	log.Printf("INFO: check for expired embargoes")
+	now := time.Now()
+	embargoes, err := svc.findEmbargoesReleasedBy(now.AddDate(0, 0, max(svc.EmbargoNoticeDays, 0)))
+	if err != nil {
+		log.Printf("ERROR: unable to find expired embargoes: %s", err.Error())
+		return
+	}
+	expired := make([]string, 0)
+	for workID, releaseDate := range embargoes {
+		if now.After(releaseDate) {
+			expired = append(expired, workID)
+		}
+	}
+	log.Printf("INFO: %d works have an expired embargo", len(expired))
+
+	released := 0
+	for _, workID := range expired {
+		if err := svc.releaseEmbargo(workID); err != nil {
+			log.Printf("ERROR: unable to release embargo on work %s: %s", workID, err.Error())
+		} else {
+			released++
+		}
+	}
+	log.Printf("INFO: embargo release complete; %d of %d works released", released, len(expired))
+
+	if svc.EmbargoNoticeDays > 0 {
+		expiring := make([]string, 0)
+		for workID, releaseDate := range embargoes {
+			if now.After(releaseDate) == false {
+				expiring = append(expiring, workID)
+			}
+		}
+		svc.processEmbargoNotices(expiring)
+	}
+}
+
+// processEmbargoNotices emails the depositor of each published work with an embargo that will be released
+// within the notice period. Each embargo is only noticed once; the notice field is cleared on release.
+func (svc *serviceContext) processEmbargoNotices(expiring []string) {
+	sent := 0
+	for _, workID := range expiring {
+		tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+		if err != nil {
+			log.Printf("ERROR: unable to get work %s for embargo notice: %s", workID, err.Error())
+			continue
+		}
+		fields := tgtObj.Fields()
+		if fields["embargo-notice-sent"] != "" || fields["draft"] != "false" || isTrashed(tgtObj) {
+			continue
+		}
+		releaseDate, err := time.Parse(svc.TimeFormat, fields["embargo-release"])
+		if err != nil || time.Now().After(releaseDate) {
+			continue
+		}
+
+		work, err := svc.parseWork(tgtObj, false)
+		if err != nil {
+			log.Printf("ERROR: unable to parse work %s for embargo notice: %s", workID, err.Error())
+			continue
+		}
+		notice := svc.newEmailData(work)
+		notice.ReleaseDate = releaseDate.Format("January 2, 2006")
+		notice.Visibility = "everyone"
+		if fields["embargo-release-visibility"] == "uva" {
+			notice.Visibility = "the University of Virginia community"
+		}
+		if err := svc.sendNotification(systemActor, workID, embargoExpiringNotice, depositorEmail(tgtObj), notice); err != nil {
+			log.Printf("ERROR: unable to send embargo notice for work %s: %s", workID, err.Error())
+			continue
+		}
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("INFO: sent %d embargo expiration notices", sent)
+	}
+}
+
+// findEmbargoesReleasedBy returns the release date of all published works with an embargo release date before
+// the cutoff, keyed by work ID. Only works with a release date are fetched from the index; drafts and works in
+// the trash are skipped.
+func (svc *serviceContext) findEmbargoesReleasedBy(cutoff time.Time) (map[string]time.Time, error) {
+	offset := 0
+	limit := 1000
+	done := false
+	found := make(map[string]time.Time)
+	for done == false {
+		payload := map[string]any{
+			"fields": []string{"id", "fields"},
+			"filter": fmt.Sprintf("fields.draft=false AND %s AND fields.embargo-release EXISTS AND fields.embargo-release IS NOT EMPTY", notDeletedFilter),
+			"offset": offset,
+			"limit":  limit,
+		}
+		url := fmt.Sprintf("%s/indexes/works/documents/fetch", svc.IndexURL)
+		rawResp, respErr := svc.sendPostRequest(url, payload)
+		if respErr != nil {
+			return nil, fmt.Errorf("%s", respErr.Message)
+		}
+
+		var jsonResp embargoDocumentResp
+		if err := json.Unmarshal(rawResp, &jsonResp); err != nil {
+			return nil, err
+		}
+
+		for _, doc := range jsonResp.Results {
+			if doc.Fields.EmbargoRelaeseDate == "" {
+				continue
+			}
+			releaseDate, err := time.Parse(svc.TimeFormat, doc.Fields.EmbargoRelaeseDate)
+			if err != nil {
+				log.Printf("WARNING: work %s has invalid embargo release date [%s]: %s", doc.ID, doc.Fields.EmbargoRelaeseDate, err.Error())
+				continue
+			}
+			if cutoff.After(releaseDate) {
+				found[doc.ID] = releaseDate
+			}
+		}
+
+		offset += len(jsonResp.Results)
+		if len(jsonResp.Results) == 0 || offset >= jsonResp.Total {
+			done = true
+		}
+	}
+	return found, nil
+}
+
+func (svc *serviceContext) releaseEmbargo(workID string) error {
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	if err != nil {
+		return err
+	}
+
+	// the index may lag behind easystore; re-check the work itself before changing anything
+	fields := tgtObj.Fields()
+	if fields["draft"] != "false" || isTrashed(tgtObj) {
+		log.Printf("INFO: work %s is not a published work; skipping", workID)
+		return nil
+	}
+	releaseDateStr := fields["embargo-release"]
+	if releaseDateStr == "" {
+		log.Printf("INFO: work %s no longer has an embargo release date; skipping", workID)
+		return nil
+	}
+	releaseDate, err := time.Parse(svc.TimeFormat, releaseDateStr)
+	if err != nil {
+		return fmt.Errorf("invalid release date %s: %s", releaseDateStr, err.Error())
+	}
+	if time.Now().Before(releaseDate) {
+		log.Printf("INFO: work %s embargo release date %s has not passed; skipping", workID, releaseDateStr)
+		return nil
+	}
+
+	// a release is recorded once the work is updated, so a recorded release of the same date means the embargo
+	// was put back, such as by a version restore. it is released again rather than left in place.
+	var existing int64
+	if err := svc.DB.Model(&embargoRelease{}).Where("work_id=? and release_date=?", workID, releaseDateStr).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		log.Printf("INFO: embargo for work %s with release date %s was released before and has been reinstated; release it again", workID, releaseDateStr)
+	}
+
+	priorVisibility := fields["default-visibility"]
+	releaseVisibility := fields["embargo-release-visibility"]
+	if releaseVisibility == "" {
+		releaseVisibility = "open"
+	}
+	log.Printf("INFO: release work %s embargo from %s to %s", workID, priorVisibility, releaseVisibility)
+
+	priorFields := make(map[string]string)
+	for _, fieldName := range []string{"embargo-release", "embargo-release-visibility", "embargo-notice-sent"} {
+		priorFields[fieldName] = fields[fieldName]
+	}
+	fields["default-visibility"] = releaseVisibility
+	fields["modify-date"] = time.Now().UTC().Format(svc.TimeFormat)
+	delete(fields, "embargo-release")
+	delete(fields, "embargo-release-visibility")
+	delete(fields, "embargo-notice-sent")
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		return err
+	}
+
+	auditCtx := svc.newAuditContext(systemActor, svc.Namespace, workID)
+	svc.auditVisibiliy(auditCtx, priorVisibility, releaseVisibility)
+	for _, fieldName := range []string{"embargo-release", "embargo-release-visibility", "embargo-notice-sent"} {
+		if priorFields[fieldName] != "" {
+			auditEvt := uvalibrabus.UvaAuditEvent{
+				Who:       systemComputeID,
+				FieldName: fieldName,
+				Before:    priorFields[fieldName],
+				After:     "",
+			}
+			svc.publishAuditChange(auditCtx, auditEvt)
+		}
+	}
+
+	rec := embargoRelease{
+		WorkID:            workID,
+		ReleaseDate:       releaseDateStr,
+		PriorVisibility:   priorVisibility,
+		ReleaseVisibility: releaseVisibility,
+		ReleasedAt:        time.Now(),
+	}
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "work_id"}, {Name: "release_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"prior_visibility", "release_visibility", "released_at"}),
+	}
+	if err := svc.DB.Clauses(upsert).Create(&rec).Error; err != nil {
+		log.Printf("ERROR: unable to record embargo release for work %s: %s", workID, err.Error())
+	}
+
+	svc.publishEvent(eventEmbargoRelease, svc.Namespace, workID)
+	return nil
+}
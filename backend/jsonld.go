@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	strip "github.com/grokify/html-strip-tags-go"
+)
+
+// schema.org types used to describe a work in the public view JSON-LD block
+type ldOrganization struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type ldPerson struct {
+	Type        string          `json:"@type"`
+	Name        string          `json:"name"`
+	GivenName   string          `json:"givenName,omitempty"`
+	FamilyName  string          `json:"familyName,omitempty"`
+	SameAs      string          `json:"sameAs,omitempty"`
+	Affiliation *ldOrganization `json:"affiliation,omitempty"`
+}
+
+type ldPropertyValue struct {
+	Type       string `json:"@type"`
+	PropertyID string `json:"propertyID"`
+	Value      string `json:"value"`
+}
+
+type ldMediaObject struct {
+	Type           string `json:"@type"`
+	Name           string `json:"name"`
+	ContentURL     string `json:"contentUrl"`
+	EncodingFormat string `json:"encodingFormat,omitempty"`
+}
+
+type ldThesis struct {
+	Context             string            `json:"@context"`
+	Type                string            `json:"@type"`
+	ID                  string            `json:"@id"`
+	URL                 string            `json:"url"`
+	Name                string            `json:"name"`
+	Author              ldPerson          `json:"author"`
+	Contributor         []ldPerson        `json:"contributor,omitempty"`
+	Publisher           ldOrganization    `json:"publisher"`
+	SourceOrganization  ldOrganization    `json:"sourceOrganization"`
+	InSupportOf         string            `json:"inSupportOf,omitempty"`
+	DatePublished       string            `json:"datePublished,omitempty"`
+	Description         string            `json:"description,omitempty"`
+	Keywords            []string          `json:"keywords,omitempty"`
+	InLanguage          string            `json:"inLanguage,omitempty"`
+	License             string            `json:"license,omitempty"`
+	Identifier          []ldPropertyValue `json:"identifier,omitempty"`
+	IsAccessibleForFree bool              `json:"isAccessibleForFree"`
+	Encoding            []ldMediaObject   `json:"encoding,omitempty"`
+}
+
+// buildJSONLD generates a schema.org Thesis description of a published work. Files are only
+// included when the work is open; embargoed or UVA-only files are never advertised.
+func (svc *serviceContext) buildJSONLD(work *workDetails, viewURL string, pubDate string) template.JS {
+	institution := work.Author.Institution
+	if institution == "" {
+		institution = defaultInstitution
+	}
+	uva := ldOrganization{Type: "Organization", Name: defaultInstitution}
+
+	thesis := ldThesis{
+		Context:             "https://schema.org",
+		Type:                "Thesis",
+		ID:                  viewURL,
+		URL:                 viewURL,
+		Name:                strings.TrimSpace(strip.StripTags(work.Title)),
+		Publisher:           uva,
+		SourceOrganization:  ldOrganization{Type: "Organization", Name: work.Program},
+		InSupportOf:         work.Degree,
+		DatePublished:       pubDate,
+		Description:         work.Abstract,
+		Keywords:            work.Keywords,
+		InLanguage:          work.Language,
+		License:             work.LicenseURL,
+		IsAccessibleForFree: work.Visibility == "open",
+	}
+	if work.Program == "" {
+		thesis.SourceOrganization = uva
+	}
+
+	thesis.Author = ldPerson{
+		Type:        "Person",
+		Name:        fmt.Sprintf("%s, %s", work.Author.LastName, work.Author.FirstName),
+		GivenName:   work.Author.FirstName,
+		FamilyName:  work.Author.LastName,
+		SameAs:      work.Author.ORCID,
+		Affiliation: &ldOrganization{Type: "Organization", Name: institution},
+	}
+	for _, adv := range work.Advisors {
+		if adv.LastName == "" || adv.FirstName == "" {
+			continue
+		}
+		advisor := ldPerson{
+			Type:       "Person",
+			Name:       fmt.Sprintf("%s, %s", adv.LastName, adv.FirstName),
+			GivenName:  adv.FirstName,
+			FamilyName: adv.LastName,
+		}
+		if adv.Institution != "" {
+			advisor.Affiliation = &ldOrganization{Type: "Organization", Name: adv.Institution}
+		}
+		thesis.Contributor = append(thesis.Contributor, advisor)
+	}
+
+	if doi := doiFromField(work.PersistentLink); doi != "" {
+		thesis.ID = fmt.Sprintf("%s%s", doiResolverURL, doi)
+		thesis.Identifier = append(thesis.Identifier, ldPropertyValue{Type: "PropertyValue", PropertyID: "DOI", Value: doi})
+	}
+
+	if work.Visibility == "open" {
+		for _, f := range work.Files {
+			thesis.Encoding = append(thesis.Encoding, ldMediaObject{
+				Type:           "MediaObject",
+				Name:           f.Name,
+				ContentURL:     fmt.Sprintf("%s/download?file=%s", viewURL, url.QueryEscape(f.Name)),
+				EncodingFormat: f.MimeType,
+			})
+		}
+	}
+
+	// json.Marshal escapes <, > and & so the result is safe to embed in a script element
+	out, _ := json.Marshal(thesis)
+	return template.JS(out)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
@@ -21,6 +22,16 @@ func main() {
 	log.Printf("===> Libra3 is starting up <===")
 	cfg := getConfiguration()
 	svc := initializeService(Version, cfg)
+	if cfg.embargoInterval > 0 {
+		svc.startEmbargoProcessor(time.Duration(cfg.embargoInterval) * time.Minute)
+	}
+	svc.startEventDispatcher(30 * time.Second)
+	if cfg.trashRetention > 0 {
+		svc.startTrashPurge()
+	}
+	if cfg.uploads.expireHours > 0 {
+		svc.startUploadCleanup(time.Duration(cfg.uploads.expireHours) * time.Hour)
+	}
 
 	// Set routes and start server
 	gin.SetMode(gin.ReleaseMode)
@@ -39,9 +50,14 @@ func main() {
 	router.GET("/sitemap.xml", svc.getSitemap)
 	router.GET("/robots.txt", svc.getRobotsTxt)
 
+	// OAI-PMH provider for harvesters of published works
+	router.GET("/oai", svc.oaiHandler)
+	router.POST("/oai", svc.oaiHandler)
+
 	// render a work as a static page using HTML templates
 	router.GET("/public_view/:id", svc.publicMiddleware, svc.getStaticPage)
 	router.GET("/public_view/:id/download", svc.publicMiddleware, svc.downloadPublishedFile)
+	router.GET("/public_view/:id/cite", svc.publicMiddleware, svc.getCitation)
 
 	api := router.Group("/api", svc.userMiddleware)
 	{
@@ -58,8 +74,21 @@ func main() {
 		api.DELETE("/works/:id/files/:name", svc.deleteFile)
 		api.PUT("/works/:id/files/rename", svc.renameFile)
 		api.GET("/works/:id/files/:name", svc.downloadDraftFile)
+		api.POST("/works/:id/uploads", svc.initUpload)
+		api.GET("/works/:id/uploads/:uid", svc.getUploadStatus)
+		api.PUT("/works/:id/uploads/:uid", svc.uploadChunk)
+		api.POST("/works/:id/uploads/:uid/complete", svc.completeUpload)
+		api.DELETE("/works/:id/uploads/:uid", svc.cancelUpload)
 		api.PUT("/works/:id", svc.updateWork)
+		api.GET("/works/:id/readiness", svc.getReadiness)
+		api.GET("/works/:id/versions", svc.getVersions)
+		api.GET("/works/:id/versions/diff", svc.getVersionDiff)
 		api.POST("/works/:id/publish", svc.publishWork)
+		api.POST("/works/:id/review", svc.submitForReview)
+		api.POST("/works/:id/review/decision", svc.reviewDecision)
+		api.GET("/works/:id/collaborators", svc.getCollaborators)
+		api.POST("/works/:id/collaborators", svc.addCollaborator)
+		api.DELETE("/works/:id/collaborators/:cid", svc.removeCollaborator)
 
 		// user search of all works
 		api.GET("/works/search", svc.userSearch)
@@ -69,6 +98,11 @@ func main() {
 		registrar := api.Group("/registrar", svc.registrarMiddleware)
 		{
 			registrar.POST("/register", svc.submitOptionalRegistrations)
+			registrar.POST("/register/import", svc.importRegistrations)
+			registrar.DELETE("/works/:id", svc.cancelRegistration)
+			registrar.PUT("/works/:id", svc.updateRegistration)
+			registrar.PUT("/works/:id/depositor", svc.transferRegistration)
+			registrar.POST("/works/:id/invite", svc.resendInvitation)
 			registrar.GET("/sis", svc.sisDepositStatusSearch)
 			registrar.GET("/optional", svc.optionalDepositStatusSearch)
 		}
@@ -79,10 +113,18 @@ func main() {
 			admin.POST("/impersonate/:computeID", svc.adminImpersonateUser)
 			admin.GET("/search", svc.adminSearch)
 			admin.DELETE("/works/:id", svc.adminDeleteWork)
+			admin.GET("/trash", svc.adminGetTrash)
+			admin.POST("/trash/:id/restore", svc.adminRestoreWork)
 			admin.DELETE("/works/:id/publish", svc.adminUnpublishWork)
 			admin.POST("/works/:id/files/:name/replace", svc.replaceFile)
 			admin.PUT("/works/:id/published", svc.adminUpdatePublishedDate)
+			admin.PUT("/works/:id/depositor", svc.adminChangeDepositor)
+			admin.POST("/works/:id/versions/:version/restore", svc.adminRestoreVersion)
 			admin.POST("/mimetypes", svc.adminUpdateMimeTypes)
+			admin.GET("/events", svc.adminGetEvents)
+			admin.POST("/events/replay", svc.adminReplayEvents)
+			admin.POST("/events/:id/replay", svc.adminReplayEvents)
+			admin.GET("/audits", svc.adminGetSystemAudits)
 		}
 	}
 
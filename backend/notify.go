@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	strip "github.com/grokify/html-strip-tags-go"
+	"github.com/uvalib/easystore/uvaeasystore"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+)
+
+// emailMessage is a rendered plain text email ready to be sent
+type emailMessage struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// notifier delivers email messages. SMTP is used in production; the dev notifier logs messages and
+// optionally writes them to a directory so they can be inspected.
+type notifier interface {
+	send(msg *emailMessage) error
+}
+
+type smtpNotifier struct {
+	host string
+	port int
+	user string
+	pass string
+}
+
+func (n *smtpNotifier) send(msg *emailMessage) error {
+	var auth smtp.Auth
+	if n.user != "" {
+		auth = smtp.PlainAuth("", n.user, n.pass, n.host)
+	}
+	addr := fmt.Sprintf("%s:%d", n.host, n.port)
+	return smtp.SendMail(addr, auth, msg.From, msg.To, msg.bytes())
+}
+
+type devNotifier struct {
+	dir string
+}
+
+func (n *devNotifier) send(msg *emailMessage) error {
+	log.Printf("INFO: dev mode email to %s: %s", strings.Join(msg.To, ", "), msg.Subject)
+	if n.dir == "" {
+		log.Printf("INFO: dev mode email body:\n%s", msg.Body)
+		return nil
+	}
+	fileName := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405.000"), strings.Join(msg.To, "_"))
+	return os.WriteFile(filepath.Join(n.dir, fileName), msg.bytes(), 0644)
+}
+
+// bytes formats the message as RFC 822 mail
+func (msg *emailMessage) bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// notification describes one type of email; the template is a file in static/emails and the stamp
+// field is the easystore field set to the send time once the email has gone out. Notifications
+// without a stamp field are not recorded on the work.
+type notification struct {
+	Template   string
+	Subject    string
+	StampField string
+}
+
+var (
+	invitationNotice      = notification{Template: "invitation.txt", Subject: "Deposit your thesis in Libra", StampField: "invitation-sent"}
+	submittedNotice       = notification{Template: "submitted.txt", Subject: "Your thesis has been submitted to Libra", StampField: "submitted-sent"}
+	embargoExpiringNotice = notification{Template: "embargo.txt", Subject: "The access restriction on your thesis is ending", StampField: "embargo-notice-sent"}
+	unpublishedNotice     = notification{Template: "unpublished.txt", Subject: "Your thesis has been withdrawn from Libra", StampField: "unpublished-sent"}
+)
+
+// emailData is passed to every notification template
+type emailData struct {
+	FirstName   string
+	LastName    string
+	Title       string
+	Degree      string
+	Program     string
+	WorkURL     string
+	PublicURL   string
+	DOI         string
+	ReleaseDate string
+	Visibility  string
+	Reason      string
+	Permission  string
+	Decision    string
+	Comments    string
+}
+
+func (svc *serviceContext) newEmailData(work *workDetails) emailData {
+	data := emailData{
+		FirstName: work.Author.FirstName,
+		LastName:  work.Author.LastName,
+		Title:     strings.TrimSpace(strip.StripTags(work.Title)),
+		Degree:    work.Degree,
+		Program:   work.Program,
+		WorkURL:   fmt.Sprintf("%s/etd/%s", svc.EtdURL, work.ID),
+		PublicURL: fmt.Sprintf("%s/public_view/%s", svc.EtdURL, work.ID),
+	}
+	if doi := doiFromField(work.PersistentLink); doi != "" {
+		data.DOI = fmt.Sprintf("%s%s", doiResolverURL, doi)
+	}
+	return data
+}
+
+func initNotifier(cfg *configData) (notifier, *template.Template) {
+	log.Printf("INFO: load email templates")
+	tpl, err := template.ParseGlob("./static/emails/*.txt")
+	if err != nil {
+		log.Fatalf("unable to load email templates: %s", err.Error())
+	}
+	if cfg.email.host == "" {
+		log.Printf("INFO: email notifications are in dev mode and will not be sent")
+		return &devNotifier{dir: cfg.dev.emailDir}, tpl
+	}
+	log.Printf("INFO: email notifications will be sent with %s:%d", cfg.email.host, cfg.email.port)
+	return &smtpNotifier{host: cfg.email.host, port: cfg.email.port, user: cfg.email.user, pass: cfg.email.pass}, tpl
+}
+
+// depositorEmail returns the address used to contact the depositor of a work
+func depositorEmail(tgtObj uvaeasystore.EasyStoreObject) string {
+	depositor := tgtObj.Fields()["depositor"]
+	if depositor == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@virginia.edu", depositor)
+}
+
+// sendNotification renders and sends a notification about a work, then stamps the notification field on
+// the work and audits the change. The work is reloaded before the stamp is applied so callers may pass
+// an object that has since been updated.
+func (svc *serviceContext) sendNotification(actor auditActor, workID string, notice notification, recipient string, data emailData) error {
+	if recipient == "" {
+		return fmt.Errorf("work %s has no recipient for %s", workID, notice.Template)
+	}
+
+	var body bytes.Buffer
+	if err := svc.EmailTemplates.ExecuteTemplate(&body, notice.Template, data); err != nil {
+		return fmt.Errorf("unable to render %s: %s", notice.Template, err.Error())
+	}
+	msg := emailMessage{
+		From:    svc.EmailSender,
+		To:      []string{recipient},
+		Subject: notice.Subject,
+		Body:    body.String(),
+	}
+	log.Printf("INFO: send %s for work %s to %s", notice.Template, workID, recipient)
+	if err := svc.Notifier.send(&msg); err != nil {
+		return fmt.Errorf("unable to send %s to %s: %s", notice.Template, recipient, err.Error())
+	}
+	if notice.StampField == "" {
+		return nil
+	}
+
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	if err != nil {
+		return fmt.Errorf("%s was sent but work could not be loaded to record it: %s", notice.Template, err.Error())
+	}
+	fields := tgtObj.Fields()
+	before := fields[notice.StampField]
+	fields[notice.StampField] = time.Now().UTC().Format(svc.TimeFormat)
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		return fmt.Errorf("%s was sent but could not be recorded: %s", notice.Template, err.Error())
+	}
+
+	auditEvt := uvalibrabus.UvaAuditEvent{
+		Who:       actor.computeID,
+		FieldName: notice.StampField,
+		Before:    before,
+		After:     fields[notice.StampField],
+	}
+	svc.publishAuditEvent(actor, svc.Namespace, workID, auditEvt)
+	return nil
+}
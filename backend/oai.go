@@ -0,0 +1,735 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	librametadata "github.com/uvalib/libra-metadata"
+)
+
+// number of records returned in a single list response before a resumption token is issued
+const oaiPageSize = 100
+
+// OAI datestamps use the finest granularity defined by the protocol
+const oaiDateFormat = "2006-01-02T15:04:05Z"
+
+// setSpec prefixes for program and degree sets. Set names are flat; a colon would declare a set hierarchy.
+const (
+	oaiProgramSet = "program_"
+	oaiDegreeSet  = "degree_"
+)
+
+// oaiResponse is the top-level OAI-PMH envelope. Only one of the verb elements will be populated.
+type oaiResponse struct {
+	XMLName             xml.Name                `xml:"OAI-PMH"`
+	Xmlns               string                  `xml:"xmlns,attr"`
+	XmlnsXsi            string                  `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string                  `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string                  `xml:"responseDate"`
+	Request             oaiRequest              `xml:"request"`
+	Errors              []oaiError              `xml:"error,omitempty"`
+	Identify            *oaiIdentify            `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *oaiListSets            `xml:"ListSets,omitempty"`
+	ListIdentifiers     *oaiListIdentifiers     `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiListRecords         `xml:"ListRecords,omitempty"`
+	GetRecord           *oaiGetRecord           `xml:"GetRecord,omitempty"`
+}
+
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormat struct {
+	MetadataPrefix    string `xml:"metadataPrefix"`
+	Schema            string `xml:"schema"`
+	MetadataNamespace string `xml:"metadataNamespace"`
+}
+
+type oaiListMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiSet struct {
+	SetSpec string `xml:"setSpec"`
+	SetName string `xml:"setName"`
+}
+
+type oaiListSets struct {
+	Sets []oaiSet `xml:"set"`
+}
+
+type oaiHeader struct {
+	Status     string   `xml:"status,attr,omitempty"`
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiMetadata struct {
+	Body any `xml:",any"`
+}
+
+// oaiRecord is a work header and its metadata; deleted records only have a header
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiListIdentifiers struct {
+	Headers         []oaiHeader         `xml:"header"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiListRecords struct {
+	Records         []oaiRecord         `xml:"record"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiGetRecord struct {
+	Record oaiRecord `xml:"record"`
+}
+
+// oaiDC is the simple dublin core (oai_dc) representation of a work
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOaiDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          string   `xml:"dc:title"`
+	Creator        string   `xml:"dc:creator"`
+	Subject        []string `xml:"dc:subject"`
+	Description    string   `xml:"dc:description,omitempty"`
+	Publisher      string   `xml:"dc:publisher"`
+	Contributor    []string `xml:"dc:contributor"`
+	Date           string   `xml:"dc:date,omitempty"`
+	Type           []string `xml:"dc:type"`
+	Identifier     []string `xml:"dc:identifier"`
+	Language       string   `xml:"dc:language,omitempty"`
+	Rights         string   `xml:"dc:rights,omitempty"`
+}
+
+// etdmsThesis is the ETD-MS 1.1 representation of a work
+type etdmsThesis struct {
+	XMLName        xml.Name           `xml:"thesis"`
+	Xmlns          string             `xml:"xmlns,attr"`
+	XmlnsXsi       string             `xml:"xmlns:xsi,attr"`
+	SchemaLocation string             `xml:"xsi:schemaLocation,attr"`
+	Title          string             `xml:"title"`
+	Creator        string             `xml:"creator"`
+	Subject        []string           `xml:"subject"`
+	Description    string             `xml:"description,omitempty"`
+	Publisher      string             `xml:"publisher"`
+	Contributor    []etdmsContributor `xml:"contributor"`
+	Date           string             `xml:"date,omitempty"`
+	Type           string             `xml:"type"`
+	Identifier     []string           `xml:"identifier"`
+	Language       string             `xml:"language,omitempty"`
+	Rights         string             `xml:"rights,omitempty"`
+	Degree         struct {
+		Name       string `xml:"name"`
+		Discipline string `xml:"discipline,omitempty"`
+		Grantor    string `xml:"grantor"`
+	} `xml:"degree"`
+}
+
+type etdmsContributor struct {
+	Role string `xml:"role,attr"`
+	Name string `xml:",chardata"`
+}
+
+// oaiResumption is the state carried between list requests in an opaque resumption token
+type oaiResumption struct {
+	Prefix string `json:"p"`
+	Set    string `json:"s,omitempty"`
+	From   string `json:"f,omitempty"`
+	Until  string `json:"u,omitempty"`
+	Offset int    `json:"o"`
+}
+
+// oaiDocument is an index document containing the metadata needed for OAI records
+type oaiDocument struct {
+	ID         string                `json:"id"`
+	ModifiedAt string                `json:"modified"`
+	Metadata   librametadata.ETDWork `json:"metadata"`
+	Fields     struct {
+		CreateDate  string `json:"create-date"`
+		Doi         string `json:"doi"`
+		PublishDate string `json:"publish-date"`
+		Draft       string `json:"draft"`
+		Deleted     string `json:"deleted"`
+	} `json:"fields"`
+}
+
+// oaiDocumentResp is a Meilisearch documents/fetch response containing OAI documents
+type oaiDocumentResp struct {
+	Results []oaiDocument `json:"results"`
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+}
+
+var oaiFormats = []oaiMetadataFormat{
+	{
+		MetadataPrefix:    "oai_dc",
+		Schema:            "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		MetadataNamespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+	},
+	{
+		MetadataPrefix:    "etdms",
+		Schema:            "http://www.ndltd.org/standards/metadata/etdms/1.1/etdms11.xsd",
+		MetadataNamespace: "http://www.ndltd.org/standards/metadata/etdms/1.1/",
+	},
+}
+
+// oaiHandler is the single entry point for all OAI-PMH verbs; both GET and POST are supported per the spec
+func (svc *serviceContext) oaiHandler(c *gin.Context) {
+	verb := c.Request.FormValue("verb")
+	log.Printf("INFO: oai-pmh %s request [%s]", verb, c.Request.URL.RawQuery)
+	resp := oaiResponse{
+		Xmlns:          "http://www.openarchives.org/OAI/2.0/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiDateFormat),
+	}
+	resp.Request.BaseURL = fmt.Sprintf("%s/oai", svc.EtdURL)
+
+	allowed := map[string][]string{
+		"Identify":            {},
+		"ListMetadataFormats": {"identifier"},
+		"ListSets":            {"resumptionToken"},
+		"ListIdentifiers":     {"metadataPrefix", "from", "until", "set", "resumptionToken"},
+		"ListRecords":         {"metadataPrefix", "from", "until", "set", "resumptionToken"},
+		"GetRecord":           {"identifier", "metadataPrefix"},
+	}
+	argNames, validVerb := allowed[verb]
+	if validVerb == false {
+		resp.Errors = append(resp.Errors, oaiError{Code: "badVerb", Message: fmt.Sprintf("illegal OAI verb [%s]", verb)})
+		svc.sendOAIResponse(c, &resp)
+		return
+	}
+	for key, vals := range c.Request.Form {
+		if key == "verb" {
+			continue
+		}
+		if len(vals) > 1 || slices.Contains(argNames, key) == false {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: fmt.Sprintf("illegal or repeated argument [%s]", key)})
+			svc.sendOAIResponse(c, &resp)
+			return
+		}
+	}
+
+	resp.Request.Verb = verb
+	resp.Request.Identifier = c.Request.FormValue("identifier")
+	resp.Request.MetadataPrefix = c.Request.FormValue("metadataPrefix")
+	resp.Request.From = c.Request.FormValue("from")
+	resp.Request.Until = c.Request.FormValue("until")
+	resp.Request.Set = c.Request.FormValue("set")
+	resp.Request.ResumptionToken = c.Request.FormValue("resumptionToken")
+
+	switch verb {
+	case "Identify":
+		svc.oaiIdentify(&resp)
+	case "ListMetadataFormats":
+		svc.oaiListMetadataFormats(&resp)
+	case "ListSets":
+		svc.oaiListSets(&resp)
+	case "GetRecord":
+		svc.oaiGetRecord(&resp)
+	default:
+		svc.oaiListWorks(&resp, verb == "ListRecords")
+	}
+	svc.sendOAIResponse(c, &resp)
+}
+
+func (svc *serviceContext) sendOAIResponse(c *gin.Context, resp *oaiResponse) {
+	out, err := xml.MarshalIndent(resp, "", "   ")
+	if err != nil {
+		log.Printf("ERROR: unable to render oai response: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+func (svc *serviceContext) oaiIdentify(resp *oaiResponse) {
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    "LibraETD: Online Archive of University of Virginia Scholarship",
+		BaseURL:           resp.Request.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        "libra@virginia.edu",
+		EarliestDatestamp: "1970-01-01T00:00:00Z",
+		DeletedRecord:     "transient",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+}
+
+func (svc *serviceContext) oaiListMetadataFormats(resp *oaiResponse) {
+	if resp.Request.Identifier != "" {
+		workID, err := svc.oaiWorkID(resp.Request.Identifier)
+		if err == nil {
+			var docs *oaiDocumentResp
+			docs, err = svc.fetchOAIDocument(workID)
+			if err == nil && len(docs.Results) == 0 && svc.getWithdrawal(workID) == nil {
+				err = fmt.Errorf("%s does not exist", resp.Request.Identifier)
+			}
+		}
+		if err != nil {
+			resp.Errors = append(resp.Errors, oaiError{Code: "idDoesNotExist", Message: err.Error()})
+			return
+		}
+	}
+	resp.ListMetadataFormats = &oaiListMetadataFormats{Formats: oaiFormats}
+}
+
+func (svc *serviceContext) oaiListSets(resp *oaiResponse) {
+	if resp.Request.ResumptionToken != "" {
+		resp.Errors = append(resp.Errors, oaiError{Code: "badResumptionToken", Message: "set lists are never paged"})
+		return
+	}
+
+	var programs []program
+	if err := svc.DB.Raw("select id, type, sis_key, program from programs order by program").Scan(&programs).Error; err != nil {
+		log.Printf("ERROR: unable to load programs for oai sets: %s", err.Error())
+	}
+	var degrees []degree
+	if err := svc.DB.Raw("select id, type, sis_key, degree from degrees order by degree").Scan(&degrees).Error; err != nil {
+		log.Printf("ERROR: unable to load degrees for oai sets: %s", err.Error())
+	}
+
+	sets := oaiListSets{}
+	for _, p := range programs {
+		sets.Sets = append(sets.Sets, oaiSet{SetSpec: fmt.Sprintf("%s%d", oaiProgramSet, p.ID), SetName: p.Program})
+	}
+	for _, d := range degrees {
+		sets.Sets = append(sets.Sets, oaiSet{SetSpec: fmt.Sprintf("%s%d", oaiDegreeSet, d.ID), SetName: d.Degree})
+	}
+	resp.ListSets = &sets
+}
+
+func (svc *serviceContext) oaiGetRecord(resp *oaiResponse) {
+	if resp.Request.Identifier == "" || resp.Request.MetadataPrefix == "" {
+		resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: "identifier and metadataPrefix are required"})
+		return
+	}
+	if oaiValidPrefix(resp.Request.MetadataPrefix) == false {
+		resp.Errors = append(resp.Errors, oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("%s is not supported", resp.Request.MetadataPrefix)})
+		return
+	}
+	workID, err := svc.oaiWorkID(resp.Request.Identifier)
+	if err != nil {
+		resp.Errors = append(resp.Errors, oaiError{Code: "idDoesNotExist", Message: err.Error()})
+		return
+	}
+
+	docs, err := svc.fetchOAIDocument(workID)
+	if err != nil {
+		log.Printf("ERROR: oai get record %s failed: %s", workID, err.Error())
+		resp.Errors = append(resp.Errors, oaiError{Code: "idDoesNotExist", Message: err.Error()})
+		return
+	}
+	if len(docs.Results) == 0 {
+		if rec := svc.getWithdrawal(workID); rec != nil && rec.Namespace == svc.Namespace {
+			resp.GetRecord = &oaiGetRecord{Record: oaiRecord{Header: svc.oaiDeletedHeader(rec)}}
+			return
+		}
+		resp.Errors = append(resp.Errors, oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%s does not exist", resp.Request.Identifier)})
+		return
+	}
+
+	setLookup := svc.oaiSetLookup()
+	resp.GetRecord = &oaiGetRecord{Record: svc.oaiRecordFromDoc(docs, 0, resp.Request.MetadataPrefix, setLookup)}
+}
+
+func (svc *serviceContext) oaiListWorks(resp *oaiResponse, includeMetadata bool) {
+	state := oaiResumption{}
+	if resp.Request.ResumptionToken != "" {
+		if resp.Request.MetadataPrefix != "" || resp.Request.Set != "" || resp.Request.From != "" || resp.Request.Until != "" {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: "resumptionToken is an exclusive argument"})
+			return
+		}
+		rawToken, err := base64.RawURLEncoding.DecodeString(resp.Request.ResumptionToken)
+		if err == nil {
+			err = json.Unmarshal(rawToken, &state)
+		}
+		if err != nil {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badResumptionToken", Message: "invalid resumption token"})
+			return
+		}
+	} else {
+		if resp.Request.MetadataPrefix == "" {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: "metadataPrefix is required"})
+			return
+		}
+		state.Prefix = resp.Request.MetadataPrefix
+		state.Set = resp.Request.Set
+		state.From = resp.Request.From
+		state.Until = resp.Request.Until
+	}
+
+	if oaiValidPrefix(state.Prefix) == false {
+		resp.Errors = append(resp.Errors, oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("%s is not supported", state.Prefix)})
+		return
+	}
+
+	var fromDate, untilDate time.Time
+	filters := make([]string, 0)
+	if state.From != "" {
+		var err error
+		fromDate, err = parseOAIDate(state.From, false)
+		if err != nil {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: err.Error()})
+			return
+		}
+		filters = append(filters, fmt.Sprintf("modifiedUnix >= %d", fromDate.Unix()))
+	}
+	if state.Until != "" {
+		var err error
+		untilDate, err = parseOAIDate(state.Until, true)
+		if err != nil {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: err.Error()})
+			return
+		}
+		filters = append(filters, fmt.Sprintf("modifiedUnix <= %d", untilDate.Unix()))
+	}
+	if state.Set != "" {
+		setFilter, err := svc.oaiSetFilter(state.Set)
+		if err != nil {
+			resp.Errors = append(resp.Errors, oaiError{Code: "badArgument", Message: err.Error()})
+			return
+		}
+		filters = append(filters, setFilter)
+	}
+
+	// the list is the published works in the index followed by the deleted records. withdrawn works are
+	// no longer in any set, so deleted records are only listed when no set is requested.
+	docs, err := svc.fetchOAIDocuments(filters, state.Offset, oaiPageSize)
+	if err != nil {
+		log.Printf("ERROR: oai list request failed: %s", err.Error())
+		resp.Errors = append(resp.Errors, oaiError{Code: "noRecordsMatch", Message: err.Error()})
+		return
+	}
+	deletedQuery := svc.DB.Model(&withdrawal{}).Where("namespace=?", svc.Namespace)
+	if state.From != "" {
+		deletedQuery = deletedQuery.Where("withdrawn_at >= ?", fromDate)
+	}
+	if state.Until != "" {
+		deletedQuery = deletedQuery.Where("withdrawn_at <= ?", untilDate)
+	}
+	var deletedTotal int64
+	deleted := make([]withdrawal, 0)
+	if state.Set == "" {
+		if err := deletedQuery.Count(&deletedTotal).Error; err != nil {
+			log.Printf("ERROR: unable to count oai deleted records: %s", err.Error())
+		}
+		if deletedRemain := oaiPageSize - len(docs.Results); deletedTotal > 0 && deletedRemain > 0 {
+			deletedOffset := max(state.Offset-docs.Total, 0)
+			if err := deletedQuery.Order("withdrawn_at, id").Offset(deletedOffset).Limit(deletedRemain).Find(&deleted).Error; err != nil {
+				log.Printf("ERROR: unable to get oai deleted records: %s", err.Error())
+			}
+		}
+	}
+	total := docs.Total + int(deletedTotal)
+	pageSize := len(docs.Results) + len(deleted)
+	if pageSize == 0 {
+		resp.Errors = append(resp.Errors, oaiError{Code: "noRecordsMatch", Message: "no records match the request"})
+		return
+	}
+
+	var token *oaiResumptionToken
+	if state.Offset > 0 || state.Offset+pageSize < total {
+		token = &oaiResumptionToken{CompleteListSize: total, Cursor: state.Offset}
+		if state.Offset+pageSize < total {
+			next := state
+			next.Offset += pageSize
+			rawToken, _ := json.Marshal(next)
+			token.Token = base64.RawURLEncoding.EncodeToString(rawToken)
+		}
+	}
+
+	setLookup := svc.oaiSetLookup()
+	if includeMetadata {
+		list := oaiListRecords{ResumptionToken: token}
+		for idx := range docs.Results {
+			list.Records = append(list.Records, svc.oaiRecordFromDoc(docs, idx, state.Prefix, setLookup))
+		}
+		for _, rec := range deleted {
+			list.Records = append(list.Records, oaiRecord{Header: svc.oaiDeletedHeader(&rec)})
+		}
+		resp.ListRecords = &list
+	} else {
+		list := oaiListIdentifiers{ResumptionToken: token}
+		for idx := range docs.Results {
+			list.Headers = append(list.Headers, svc.oaiRecordFromDoc(docs, idx, "", setLookup).Header)
+		}
+		for _, rec := range deleted {
+			list.Headers = append(list.Headers, svc.oaiDeletedHeader(&rec))
+		}
+		resp.ListIdentifiers = &list
+	}
+}
+
+// oaiDeletedHeader is the header of a work that was once published but has since been withdrawn
+func (svc *serviceContext) oaiDeletedHeader(rec *withdrawal) oaiHeader {
+	return oaiHeader{
+		Status:     "deleted",
+		Identifier: fmt.Sprintf("%s%s", svc.oaiIdentifierPrefix(), rec.WorkID),
+		Datestamp:  rec.WithdrawnAt.UTC().Format(oaiDateFormat),
+	}
+}
+
+// fetchOAIDocuments pages thru published works in the index using the same documents/fetch API as the sitemap
+func (svc *serviceContext) fetchOAIDocuments(filters []string, offset, limit int) (*oaiDocumentResp, error) {
+	filter := append([]string{"fields.draft=false", notDeletedFilter}, filters...)
+	payload := map[string]any{"filter": strings.Join(filter, " AND "),
+		"fields": []string{"id", "modified", "metadata", "fields"},
+		"offset": offset,
+		"limit":  limit,
+	}
+	url := fmt.Sprintf("%s/indexes/works/documents/fetch", svc.IndexURL)
+	rawResp, respErr := svc.sendPostRequest(url, payload)
+	if respErr != nil {
+		return nil, fmt.Errorf("%s", respErr.Message)
+	}
+
+	var jsonResp oaiDocumentResp
+	if err := json.Unmarshal(rawResp, &jsonResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %s", err.Error())
+	}
+	return &jsonResp, nil
+}
+
+// fetchOAIDocument gets a single work from the index by ID rather than with a filter, as id is not a filterable
+// attribute. The response has no results if the work is not in the index or is not a published work.
+func (svc *serviceContext) fetchOAIDocument(workID string) (*oaiDocumentResp, error) {
+	docURL := fmt.Sprintf("%s/indexes/works/documents/%s?fields=id,modified,metadata,fields", svc.IndexURL, url.PathEscape(workID))
+	docs := oaiDocumentResp{Limit: 1}
+	rawResp, respErr := svc.sendGetRequest(docURL)
+	if respErr != nil {
+		if respErr.StatusCode == http.StatusNotFound {
+			return &docs, nil
+		}
+		return nil, fmt.Errorf("%s", respErr.Message)
+	}
+
+	var doc oaiDocument
+	if err := json.Unmarshal(rawResp, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %s", err.Error())
+	}
+	if doc.Fields.Draft == "false" && doc.Fields.Deleted == "" {
+		docs.Results = append(docs.Results, doc)
+		docs.Total = 1
+	}
+	return &docs, nil
+}
+
+// oaiSetLookup maps program and degree names to the setSpec values used to advertise them
+func (svc *serviceContext) oaiSetLookup() map[string]string {
+	lookup := make(map[string]string)
+	var programs []program
+	if err := svc.DB.Raw("select id, program from programs").Scan(&programs).Error; err != nil {
+		log.Printf("ERROR: unable to load programs for oai sets: %s", err.Error())
+	}
+	for _, p := range programs {
+		lookup[fmt.Sprintf("program|%s", p.Program)] = fmt.Sprintf("%s%d", oaiProgramSet, p.ID)
+	}
+	var degrees []degree
+	if err := svc.DB.Raw("select id, degree from degrees").Scan(&degrees).Error; err != nil {
+		log.Printf("ERROR: unable to load degrees for oai sets: %s", err.Error())
+	}
+	for _, d := range degrees {
+		lookup[fmt.Sprintf("degree|%s", d.Degree)] = fmt.Sprintf("%s%d", oaiDegreeSet, d.ID)
+	}
+	return lookup
+}
+
+// oaiSetFilter converts a setSpec like program_12 into an index filter on the program name
+func (svc *serviceContext) oaiSetFilter(setSpec string) (string, error) {
+	if strings.HasPrefix(setSpec, oaiProgramSet) {
+		setID, err := strconv.ParseUint(strings.TrimPrefix(setSpec, oaiProgramSet), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid set %s", setSpec)
+		}
+		var tgtProgram program
+		if err := svc.DB.Raw("select id, program from programs where id=?", setID).Scan(&tgtProgram).Error; err != nil || tgtProgram.ID == 0 {
+			return "", fmt.Errorf("set %s does not exist", setSpec)
+		}
+		return fmt.Sprintf("metadata.program = %q", tgtProgram.Program), nil
+	}
+	if strings.HasPrefix(setSpec, oaiDegreeSet) {
+		setID, err := strconv.ParseUint(strings.TrimPrefix(setSpec, oaiDegreeSet), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid set %s", setSpec)
+		}
+		var tgtDegree degree
+		if err := svc.DB.Raw("select id, degree from degrees where id=?", setID).Scan(&tgtDegree).Error; err != nil || tgtDegree.ID == 0 {
+			return "", fmt.Errorf("set %s does not exist", setSpec)
+		}
+		return fmt.Sprintf("metadata.degree = %q", tgtDegree.Degree), nil
+	}
+	return "", fmt.Errorf("invalid set %s", setSpec)
+}
+
+func (svc *serviceContext) oaiIdentifierPrefix() string {
+	host := "libra.virginia.edu"
+	if parsed, err := url.Parse(svc.EtdURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return fmt.Sprintf("oai:%s:", host)
+}
+
+func (svc *serviceContext) oaiWorkID(identifier string) (string, error) {
+	prefix := svc.oaiIdentifierPrefix()
+	if strings.HasPrefix(identifier, prefix) == false || len(identifier) == len(prefix) {
+		return "", fmt.Errorf("%s is not a valid identifier in this repository", identifier)
+	}
+	return strings.TrimPrefix(identifier, prefix), nil
+}
+
+func (svc *serviceContext) oaiRecordFromDoc(docs *oaiDocumentResp, idx int, prefix string, setLookup map[string]string) oaiRecord {
+	doc := docs.Results[idx]
+	rec := oaiRecord{}
+	rec.Header.Identifier = fmt.Sprintf("%s%s", svc.oaiIdentifierPrefix(), doc.ID)
+	datestamp := doc.ModifiedAt
+	if datestamp == "" {
+		datestamp = doc.Fields.PublishDate
+	}
+	rec.Header.Datestamp = parseDate(datestamp).UTC().Format(oaiDateFormat)
+	if spec, ok := setLookup[fmt.Sprintf("program|%s", doc.Metadata.Program)]; ok {
+		rec.Header.SetSpecs = append(rec.Header.SetSpecs, spec)
+	}
+	if spec, ok := setLookup[fmt.Sprintf("degree|%s", doc.Metadata.Degree)]; ok {
+		rec.Header.SetSpecs = append(rec.Header.SetSpecs, spec)
+	}
+
+	work := doc.Metadata
+	pubDate := ""
+	if doc.Fields.PublishDate != "" {
+		pubDate = parseDate(doc.Fields.PublishDate).Format("2006-01-02")
+	}
+	identifiers := []string{fmt.Sprintf("%s/public_view/%s", svc.EtdURL, doc.ID)}
+	if doc.Fields.Doi != "" {
+		identifiers = append(identifiers, doc.Fields.Doi)
+	}
+	creator := fmt.Sprintf("%s, %s", work.Author.LastName, work.Author.FirstName)
+	publisher := work.Author.Institution
+	if publisher == "" {
+		publisher = "University of Virginia"
+	}
+
+	switch prefix {
+	case "oai_dc":
+		dc := oaiDC{
+			XmlnsOaiDC:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+			XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+			XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+			SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+			Title:          work.Title,
+			Creator:        creator,
+			Subject:        work.Keywords,
+			Description:    work.Abstract,
+			Publisher:      publisher,
+			Date:           pubDate,
+			Type:           []string{"Text", "Thesis"},
+			Identifier:     identifiers,
+			Language:       work.Language,
+			Rights:         work.License,
+		}
+		for _, adv := range work.Advisors {
+			if adv.LastName != "" {
+				dc.Contributor = append(dc.Contributor, fmt.Sprintf("%s, %s", adv.LastName, adv.FirstName))
+			}
+		}
+		rec.Metadata = &oaiMetadata{Body: dc}
+	case "etdms":
+		thesis := etdmsThesis{
+			Xmlns:          "http://www.ndltd.org/standards/metadata/etdms/1.1/",
+			XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+			SchemaLocation: "http://www.ndltd.org/standards/metadata/etdms/1.1/ http://www.ndltd.org/standards/metadata/etdms/1.1/etdms11.xsd",
+			Title:          work.Title,
+			Creator:        creator,
+			Subject:        work.Keywords,
+			Description:    work.Abstract,
+			Publisher:      publisher,
+			Date:           pubDate,
+			Type:           "Electronic Thesis or Dissertation",
+			Identifier:     identifiers,
+			Language:       work.Language,
+			Rights:         work.License,
+		}
+		for _, adv := range work.Advisors {
+			if adv.LastName != "" {
+				thesis.Contributor = append(thesis.Contributor, etdmsContributor{Role: "advisor", Name: fmt.Sprintf("%s, %s", adv.LastName, adv.FirstName)})
+			}
+		}
+		thesis.Degree.Name = work.Degree
+		thesis.Degree.Discipline = work.Program
+		thesis.Degree.Grantor = publisher
+		rec.Metadata = &oaiMetadata{Body: thesis}
+	}
+	return rec
+}
+
+func oaiValidPrefix(prefix string) bool {
+	for _, f := range oaiFormats {
+		if f.MetadataPrefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOAIDate accepts either day or seconds granularity. When endOfDay is set, a day-only
+// date is expanded to the last second of that day so until ranges are inclusive
+func parseOAIDate(dateStr string, endOfDay bool) (time.Time, error) {
+	if parsed, err := time.Parse(oaiDateFormat, dateStr); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid date %s", dateStr)
+	}
+	if endOfDay {
+		parsed = parsed.Add(24*time.Hour - time.Second)
+	}
+	return parsed, nil
+}
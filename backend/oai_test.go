@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseOAIDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     string
+		endOfDay bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{name: "seconds granularity", date: "2024-03-05T10:11:12Z", want: time.Date(2024, 3, 5, 10, 11, 12, 0, time.UTC)},
+		{name: "seconds granularity ignores end of day", date: "2024-03-05T10:11:12Z", endOfDay: true, want: time.Date(2024, 3, 5, 10, 11, 12, 0, time.UTC)},
+		{name: "day granularity", date: "2024-03-05", want: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{name: "day granularity end of day", date: "2024-03-05", endOfDay: true, want: time.Date(2024, 3, 5, 23, 59, 59, 0, time.UTC)},
+		{name: "offset timezone", date: "2024-03-05T10:11:12-05:00", wantErr: true},
+		{name: "month only", date: "2024-03", wantErr: true},
+		{name: "invalid day", date: "2024-02-30", wantErr: true},
+		{name: "blank", date: "", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseOAIDate(tc.date, tc.endOfDay)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseOAIDate(%q) = %s; want error", tc.date, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOAIDate(%q) failed: %s", tc.date, err.Error())
+			}
+			if got.Equal(tc.want) == false {
+				t.Errorf("parseOAIDate(%q) = %s; want %s", tc.date, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOAIHandlerErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{EtdURL: "https://libraetd.lib.virginia.edu"}
+	router := gin.New()
+	router.GET("/oai", svc.oaiHandler)
+
+	for query, code := range map[string]string{
+		"":                            "badVerb",
+		"verb=Harvest":                "badVerb",
+		"verb=Identify&set=program_1": "badArgument",
+		"verb=GetRecord&identifier=a&identifier=b": "badArgument",
+	} {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/oai?"+query, nil))
+		if resp.Code != http.StatusOK {
+			t.Errorf("oai [%s] status = %d; want errors reported with %d", query, resp.Code, http.StatusOK)
+		}
+		if strings.Contains(resp.Body.String(), `code="`+code+`"`) == false {
+			t.Errorf("oai [%s] response is missing error %s:\n%s", query, code, resp.Body.String())
+		}
+	}
+}
+
+func TestOAIIdentify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{EtdURL: "https://libraetd.lib.virginia.edu"}
+	router := gin.New()
+	router.POST("/oai", svc.oaiHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/oai", strings.NewReader("verb=Identify"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var parsed oaiResponse
+	if err := xml.Unmarshal(resp.Body.Bytes(), &parsed); err != nil {
+		t.Fatalf("unable to parse Identify response: %s", err.Error())
+	}
+	if parsed.Identify == nil {
+		t.Fatalf("Identify response has no identify element:\n%s", resp.Body.String())
+	}
+	if parsed.Identify.BaseURL != "https://libraetd.lib.virginia.edu/oai" {
+		t.Errorf("baseURL = %s", parsed.Identify.BaseURL)
+	}
+	if parsed.Identify.DeletedRecord != "transient" {
+		t.Errorf("deletedRecord = %s; want transient", parsed.Identify.DeletedRecord)
+	}
+}
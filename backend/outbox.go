@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm"
+)
+
+// outbox event states. Failed events have exhausted all retries and will only be sent again when replayed by an admin.
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxFailed  = "failed"
+)
+
+const (
+	outboxMaxAttempts = 10
+	outboxBaseBackoff = 15 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxRetention   = 7 * 24 * time.Hour
+	outboxBatchSize   = 100
+	// claimed events are not picked up by another dispatcher until the claim expires, so an event
+	// claimed by an instance that stops before sending it is retried once the claim runs out
+	outboxClaimTimeout = 5 * time.Minute
+)
+
+// outboxEvent is a bus event that has been durably recorded and is waiting to be, or has been, sent to the bus
+type outboxEvent struct {
+	ID            uint64     `json:"id"`
+	EventName     string     `json:"eventName"`
+	Namespace     string     `json:"namespace"`
+	Identifier    string     `json:"identifier"`
+	Detail        []byte     `json:"-"`
+	DetailText    string     `gorm:"-" json:"detail,omitempty"`
+	AuditID       *uint64    `json:"auditID,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+func newOutboxEvent(evt *uvalibrabus.UvaBusEvent) *outboxEvent {
+	now := time.Now()
+	return &outboxEvent{
+		EventName:     evt.EventName,
+		Namespace:     evt.Namespace,
+		Identifier:    evt.Identifier,
+		Detail:        evt.Detail,
+		Status:        outboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// queueEvent records a bus event in the outbox for delivery by the dispatcher. If the outbox cannot be
+// written the event is sent directly so it is not lost while the database is unavailable.
+func (svc *serviceContext) queueEvent(evt *uvalibrabus.UvaBusEvent) {
+	if err := svc.DB.Create(newOutboxEvent(evt)).Error; err != nil {
+		log.Printf("ERROR: unable to add event %s for %s to outbox; send directly: %s", evt.EventName, evt.Identifier, err.Error())
+		if sendErr := svc.sendBusEvent(evt); sendErr != nil {
+			log.Printf("ERROR: unable to publish event %+v : %s", evt, sendErr.Error())
+		}
+		return
+	}
+	svc.notifyDispatcher()
+}
+
+// notifyDispatcher wakes the dispatcher so newly queued events are sent without waiting for the next poll
+func (svc *serviceContext) notifyDispatcher() {
+	select {
+	case svc.Events.Notify <- struct{}{}:
+	default:
+	}
+}
+
+func (svc *serviceContext) sendBusEvent(evt *uvalibrabus.UvaBusEvent) error {
+	if svc.Events.DevMode {
+		log.Printf("INFO: dev mode send %s for %s:%s detail [%s] to bus [%s] with source [%s]",
+			evt.EventName, evt.Namespace, evt.Identifier, evt.Detail, svc.Events.BusName, svc.Events.EventSource)
+		return nil
+	}
+	return svc.Events.Bus.PublishEvent(evt)
+}
+
+// startEventDispatcher sends pending outbox events to the bus whenever new events are queued, and
+// polls on the interval to retry events whose backoff has expired
+func (svc *serviceContext) startEventDispatcher(interval time.Duration) {
+	log.Printf("INFO: start event dispatcher with a %s poll interval", interval.String())
+	svc.backfillAuditOutbox()
+	ticker := time.NewTicker(interval)
+	lastPrune := time.Time{}
+	go func() {
+		for {
+			svc.dispatchEvents()
+			if time.Since(lastPrune) > time.Hour {
+				svc.pruneSentEvents()
+				lastPrune = time.Now()
+			}
+			select {
+			case <-ticker.C:
+			case <-svc.Events.Notify:
+			}
+		}
+	}()
+}
+
+// claimEvents claims a batch of due events for this dispatcher by moving their next attempt past the
+// claim timeout. Rows locked by another dispatcher are skipped so each event is only sent by one instance.
+func (svc *serviceContext) claimEvents() ([]outboxEvent, error) {
+	var claimed []outboxEvent
+	err := svc.DB.Raw(`update outbox_events set next_attempt_at=? where id in
+		(select id from outbox_events where status=? and next_attempt_at <= ? order by id asc limit ? for update skip locked)
+		returning *`, time.Now().Add(outboxClaimTimeout), outboxPending, time.Now(), outboxBatchSize).Scan(&claimed).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed, nil
+}
+
+func (svc *serviceContext) dispatchEvents() {
+	for {
+		pending, err := svc.claimEvents()
+		if err != nil {
+			log.Printf("ERROR: unable to get pending outbox events: %s", err.Error())
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		failures := 0
+		for _, rec := range pending {
+			if svc.dispatchEvent(&rec) == false {
+				failures++
+			}
+		}
+
+		// when every event in the batch failed the bus is likely down; wait for the backoff before trying more
+		if failures == len(pending) || len(pending) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// dispatchEvent sends one outbox event and records the outcome. It returns true if the event was sent.
+func (svc *serviceContext) dispatchEvent(rec *outboxEvent) bool {
+	evt := uvalibrabus.UvaBusEvent{
+		EventName:  rec.EventName,
+		Namespace:  rec.Namespace,
+		Identifier: rec.Identifier,
+		Detail:     rec.Detail,
+	}
+	sendErr := svc.sendBusEvent(&evt)
+	now := time.Now()
+	rec.Attempts++
+
+	if sendErr == nil {
+		err := svc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(rec).Updates(map[string]any{"status": outboxSent, "attempts": rec.Attempts, "sent_at": now}).Error; err != nil {
+				return err
+			}
+			if rec.AuditID != nil {
+				return tx.Model(&audit{}).Where("id=?", *rec.AuditID).Update("published_at", now).Error
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: event %d was sent but could not be marked as sent: %s", rec.ID, err.Error())
+		}
+		return true
+	}
+
+	updates := map[string]any{"attempts": rec.Attempts, "last_error": sendErr.Error()}
+	if rec.Attempts >= outboxMaxAttempts {
+		log.Printf("ERROR: event %d %s for %s failed %d times and has been dead-lettered: %s",
+			rec.ID, rec.EventName, rec.Identifier, rec.Attempts, sendErr.Error())
+		updates["status"] = outboxFailed
+	} else {
+		backoff := outboxBaseBackoff * time.Duration(1<<(rec.Attempts-1))
+		if backoff > outboxMaxBackoff {
+			backoff = outboxMaxBackoff
+		}
+		log.Printf("WARNING: event %d %s for %s failed attempt %d; retry in %s: %s",
+			rec.ID, rec.EventName, rec.Identifier, rec.Attempts, backoff.String(), sendErr.Error())
+		updates["next_attempt_at"] = now.Add(backoff)
+	}
+	if err := svc.DB.Model(rec).Updates(updates).Error; err != nil {
+		log.Printf("ERROR: unable to record failure of event %d: %s", rec.ID, err.Error())
+	}
+	return false
+}
+
+// backfillAuditOutbox queues audits that were stored before the outbox existed and never reached the bus
+func (svc *serviceContext) backfillAuditOutbox() {
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		// instances starting together must not queue the same audits twice
+		if err := tx.Exec("select pg_advisory_xact_lock(hashtext('audit-outbox-backfill'))").Error; err != nil {
+			return err
+		}
+		var unpublished []audit
+		err := tx.Where("published_at is null and not exists (select 1 from outbox_events o where o.audit_id=audits.id)").
+			Order("id asc").Find(&unpublished).Error
+		if err != nil {
+			return err
+		}
+		if len(unpublished) == 0 {
+			return nil
+		}
+		log.Printf("INFO: queue %d unpublished audits in the outbox", len(unpublished))
+		for _, rec := range unpublished {
+			auditEvt := uvalibrabus.UvaAuditEvent{Who: rec.Who, FieldName: rec.FieldName, Before: rec.Before, After: rec.After}
+			auditDetail, _ := auditEvt.Serialize()
+			outboxRec := newOutboxEvent(&uvalibrabus.UvaBusEvent{
+				EventName:  uvalibrabus.EventFieldUpdate,
+				Namespace:  rec.Namespace,
+				Identifier: rec.WorkID,
+				Detail:     auditDetail,
+			})
+			outboxRec.AuditID = &rec.ID
+			if err := tx.Create(outboxRec).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to queue unpublished audits in the outbox: %s", err.Error())
+	}
+}
+
+func (svc *serviceContext) pruneSentEvents() {
+	resp := svc.DB.Where("status=? and sent_at < ?", outboxSent, time.Now().Add(-outboxRetention)).Delete(&outboxEvent{})
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to prune sent outbox events: %s", resp.Error.Error())
+	} else if resp.RowsAffected > 0 {
+		log.Printf("INFO: pruned %d sent outbox events", resp.RowsAffected)
+	}
+}
+
+func (svc *serviceContext) adminGetEvents(c *gin.Context) {
+	status := c.DefaultQuery("status", outboxFailed)
+	if status != outboxFailed && status != outboxPending && status != outboxSent {
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid status %s", status))
+		return
+	}
+	log.Printf("INFO: get %s outbox events", status)
+	var events []outboxEvent
+	if err := svc.DB.Where("status=?", status).Order("id desc").Limit(1000).Find(&events).Error; err != nil {
+		log.Printf("ERROR: unable to get %s outbox events: %s", status, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range events {
+		events[i].DetailText = string(events[i].Detail)
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// adminReplayEvents returns failed events to the pending state for delivery by the dispatcher. A single
+// event is replayed when an id param is present, otherwise all failed events are replayed.
+func (svc *serviceContext) adminReplayEvents(c *gin.Context) {
+	claims := getJWTClaims(c)
+	query := svc.DB.Model(&outboxEvent{}).Where("status=?", outboxFailed)
+	if eventID := c.Param("id"); eventID != "" {
+		log.Printf("INFO: admin %s requests replay of event %s", claims.ComputeID, eventID)
+		query = query.Where("id=?", eventID)
+	} else {
+		log.Printf("INFO: admin %s requests replay of all failed events", claims.ComputeID)
+	}
+
+	resp := query.Updates(map[string]any{"status": outboxPending, "attempts": 0, "next_attempt_at": time.Now()})
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to replay failed events: %s", resp.Error.Error())
+		c.String(http.StatusInternalServerError, resp.Error.Error())
+		return
+	}
+	if c.Param("id") != "" && resp.RowsAffected == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("failed event %s was not found", c.Param("id")))
+		return
+	}
+
+	log.Printf("INFO: %d failed events queued for replay", resp.RowsAffected)
+	svc.publishSystemAudit(claims.auditActor(), sysAuditEventReplay, c.Param("id"), "", fmt.Sprintf("%d replayed", resp.RowsAffected))
+	svc.notifyDispatcher()
+	c.JSON(http.StatusOK, gin.H{"replayed": resp.RowsAffected})
+}
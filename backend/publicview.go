@@ -19,6 +19,11 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 	workID := c.Param("id")
 	log.Printf("INFO: generate static pubic view  %s", workID)
 
+	if rec := svc.getWithdrawal(workID); rec != nil {
+		svc.renderTombstone(c, rec)
+		return
+	}
+
 	etdWork, err := svc.getWork(c, workID, "view")
 	if err != nil {
 		if err.StatusCode == 404 {
@@ -33,6 +38,7 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 
 	type workFile struct {
 		FileName  string
+		MimeType  string
 		Downloads int
 	}
 
@@ -47,6 +53,16 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 		}
 	}
 
+	type citationStyle struct {
+		Label string
+		Text  template.HTML
+	}
+
+	type citationLink struct {
+		Format string
+		Label  string
+	}
+
 	type relatedURL struct {
 		IsURL bool
 		Value string
@@ -66,13 +82,18 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 		Abstract           string
 		Degree             string
 		Keywords           string
+		KeywordList        []string
 		Sponsors           []string
 		RelatedURLs        []relatedURL
 		Notes              string
 		Language           string
 		Citation           string
+		CitationStyles     []citationStyle
+		CitationFormats    []citationLink
 		PublishedDate      string
 		PersistentLink     string
+		DOI                string
+		JSONLD             template.JS
 		ThisYear           string
 		Files              []workFile
 		License            struct {
@@ -119,6 +140,7 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 	viewData.Degree = etdWork.Degree
 	if len(etdWork.Keywords) > 0 {
 		viewData.Keywords = strings.Join(etdWork.Keywords, "; ")
+		viewData.KeywordList = etdWork.Keywords
 	}
 	viewData.Sponsors = etdWork.Sponsors
 	for _, relVal := range etdWork.RelatedURLs {
@@ -136,6 +158,7 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 	viewData.License.Name = etdWork.License
 	viewData.License.URL = etdWork.LicenseURL
 	viewData.PersistentLink = etdWork.PersistentLink
+	viewData.DOI = doiFromField(etdWork.PersistentLink)
 
 	pubDate, dErr := time.Parse(svc.TimeFormat, etdWork.PublishedAt)
 	if dErr != nil {
@@ -149,18 +172,25 @@ func (svc *serviceContext) getStaticPage(c *gin.Context) {
 		viewData.EmbargoReleaseDate = endDate.Format("2006-01-02")
 	}
 
-	// build suggested citaion
-	//[Author LastName], [Author FirstName]. [Title]. [Author Institution], [program], [Degree], [Published Year], [DOI URI].
-	viewData.Citation = fmt.Sprintf("%s, %s. %s. %s, %s, %s, %s, %s.",
-		etdWork.Author.LastName, etdWork.Author.FirstName,
-		etdWork.Title, etdWork.Author.Institution, etdWork.Program, etdWork.Degree,
-		viewData.PublishedDate, viewData.PersistentLink)
+	// build suggested citation and the alternate citation styles
+	cite := svc.newCitation(etdWork)
+	viewData.Citation = cite.suggested()
+	for _, style := range citationStyles {
+		viewData.CitationStyles = append(viewData.CitationStyles,
+			citationStyle{Label: style.Label, Text: template.HTML(cite.render(style.Key, true))})
+	}
+	for _, format := range []string{"bibtex", "ris", "endnote", "csljson"} {
+		viewData.CitationFormats = append(viewData.CitationFormats, citationLink{Format: format, Label: citationFormats[format].Label})
+	}
 
 	// add files to the work data
 	for _, f := range etdWork.Files {
-		viewData.Files = append(viewData.Files, workFile{FileName: f.Name, Downloads: f.Downloads})
+		viewData.Files = append(viewData.Files, workFile{FileName: f.Name, MimeType: f.MimeType, Downloads: f.Downloads})
 	}
 
+	// structured metadata for crawlers
+	viewData.JSONLD = svc.buildJSONLD(etdWork, fmt.Sprintf("%s/public_view/%s", svc.EtdURL, workID), viewData.PublishedDate)
+
 	// render template as html using the data set up above
 	c.HTML(http.StatusOK, "view.html", viewData)
 }
@@ -176,6 +206,12 @@ func (svc *serviceContext) downloadPublishedFile(c *gin.Context) {
 		return
 	}
 
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: request to download file %s from deleted work %s", tgtFile, workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
 	log.Printf("INFO: check access to download files for work %s", workID)
 	access := svc.canAccessWork(c, tgtObj)
 	if access.files == false {
@@ -207,14 +243,7 @@ func (svc *serviceContext) downloadPublishedFile(c *gin.Context) {
 		Detail:     dlDetail,
 	}
 	log.Printf("INFO: publish download event %s", dlDetail)
-	if svc.Events.DevMode {
-		log.Printf("INFO: dev mode work %s send download event to bus [%s] with source [%s]", workID, svc.Events.BusName, svc.Events.EventSource)
-	} else {
-		err := svc.Events.Bus.PublishEvent(&evt)
-		if err != nil {
-			log.Printf("ERROR: unable to publish download event %+v : %s", evt, err.Error())
-		}
-	}
+	svc.queueEvent(&evt)
 
 	// redirect to the newly generated url so the client automatically does the download
 	// with no additional JS logic needed
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	strip "github.com/grokify/html-strip-tags-go"
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+)
+
+// rule severities; error rules block publication, warning rules are reported but do not
+const (
+	readinessError   = "error"
+	readinessWarning = "warning"
+	readinessOff     = "off"
+)
+
+// readinessConfig is loaded from data/readiness.json. Rules sets the severity of each check for all
+// works; overrides change the severity for works in a program and/or degree. A blank program or degree
+// in an override matches any value, and later overrides win over earlier ones.
+type readinessConfig struct {
+	Rules     map[string]string   `json:"rules"`
+	Overrides []readinessOverride `json:"overrides"`
+}
+
+type readinessOverride struct {
+	Program string            `json:"program"`
+	Degree  string            `json:"degree"`
+	Rules   map[string]string `json:"rules"`
+}
+
+type readinessIssue struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type readinessReport struct {
+	Ready    bool             `json:"ready"`
+	Errors   []readinessIssue `json:"errors"`
+	Warnings []readinessIssue `json:"warnings"`
+}
+
+// readinessCheck returns a message describing the problem, or blank if the work passes the check
+type readinessCheck func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string
+
+// readinessChecks are the checks that may be referenced by name in the readiness config
+var readinessChecks = map[string]readinessCheck{
+	"title": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(strip.StripTags(work.Title)) == "" {
+			return "a title is required"
+		}
+		return ""
+	},
+	"author": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(work.Author.FirstName) == "" || strings.TrimSpace(work.Author.LastName) == "" {
+			return "author first and last name are required"
+		}
+		return ""
+	},
+	"abstract": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(strip.StripTags(work.Abstract)) == "" {
+			return "an abstract is required"
+		}
+		return ""
+	},
+	"advisors": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if len(work.Advisors) == 0 {
+			return "at least one advisor is required"
+		}
+		for idx, advisor := range work.Advisors {
+			if strings.TrimSpace(advisor.FirstName) == "" || strings.TrimSpace(advisor.LastName) == "" {
+				return fmt.Sprintf("advisor %d is missing a first or last name", idx+1)
+			}
+		}
+		return ""
+	},
+	"degree": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(work.Degree) == "" {
+			return "a degree is required"
+		}
+		return ""
+	},
+	"program": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(work.Program) == "" {
+			return "a program is required"
+		}
+		return ""
+	},
+	"license": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(work.License) == "" {
+			return "a license is required"
+		}
+		return ""
+	},
+	"files": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if len(files) == 0 {
+			return "at least one file is required"
+		}
+		return ""
+	},
+	"pdf": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		for _, f := range files {
+			if f.MimeType() == "application/pdf" {
+				return ""
+			}
+		}
+		return "the thesis should include a PDF file"
+	},
+	"keywords": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		for _, kw := range work.Keywords {
+			if strings.TrimSpace(kw) != "" {
+				return ""
+			}
+		}
+		return "at least one keyword is recommended"
+	},
+	"language": func(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) string {
+		if strings.TrimSpace(work.Language) == "" {
+			return "a language is recommended"
+		}
+		return ""
+	},
+}
+
+func loadReadinessConfig(fileName string) (*readinessConfig, error) {
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var cfg readinessConfig
+	if err := json.Unmarshal(bytes, &cfg); err != nil {
+		return nil, err
+	}
+	validate := func(rules map[string]string) error {
+		for name, severity := range rules {
+			if _, found := readinessChecks[name]; found == false {
+				return fmt.Errorf("unknown readiness rule %s", name)
+			}
+			if severity != readinessError && severity != readinessWarning && severity != readinessOff {
+				return fmt.Errorf("invalid severity %s for readiness rule %s", severity, name)
+			}
+		}
+		return nil
+	}
+	if err := validate(cfg.Rules); err != nil {
+		return nil, err
+	}
+	for _, o := range cfg.Overrides {
+		if err := validate(o.Rules); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// rulesFor returns the severity of every rule that applies to works in the program and degree
+func (cfg *readinessConfig) rulesFor(program string, degree string) map[string]string {
+	rules := make(map[string]string)
+	for name, severity := range cfg.Rules {
+		rules[name] = severity
+	}
+	for _, o := range cfg.Overrides {
+		if o.Program != "" && strings.EqualFold(o.Program, program) == false {
+			continue
+		}
+		if o.Degree != "" && strings.EqualFold(o.Degree, degree) == false {
+			continue
+		}
+		for name, severity := range o.Rules {
+			rules[name] = severity
+		}
+	}
+	return rules
+}
+
+// checkReadiness evaluates a work and its files against the readiness rules for its program and degree
+func (svc *serviceContext) checkReadiness(work *librametadata.ETDWork, files []uvaeasystore.EasyStoreBlob) *readinessReport {
+	report := readinessReport{Ready: true, Errors: make([]readinessIssue, 0), Warnings: make([]readinessIssue, 0)}
+	rules := svc.Readiness.rulesFor(work.Program, work.Degree)
+	for name, check := range readinessChecks {
+		severity := rules[name]
+		if severity == "" || severity == readinessOff {
+			continue
+		}
+		msg := check(work, files)
+		if msg == "" {
+			continue
+		}
+		if severity == readinessError {
+			report.Ready = false
+			report.Errors = append(report.Errors, readinessIssue{Rule: name, Message: msg})
+		} else {
+			report.Warnings = append(report.Warnings, readinessIssue{Rule: name, Message: msg})
+		}
+	}
+	// checks are held in a map; sort so the report order is stable
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Rule < report.Errors[j].Rule })
+	sort.Slice(report.Warnings, func(i, j int) bool { return report.Warnings[i].Rule < report.Warnings[j].Rule })
+	return &report
+}
+
+// getReadiness reports whether a work meets the rules required for publication
+func (svc *serviceContext) getReadiness(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	if claims == nil {
+		log.Printf("INFO: reject anonymous readiness request for work %s", workID)
+		c.String(http.StatusUnauthorized, "sign in is required")
+		return
+	}
+	log.Printf("INFO: %s requests publication readiness of work %s", claims.ComputeID, workID)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata|uvaeasystore.Files)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for readiness check: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if isTrashed(tgtObj) {
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+	if svc.canAccessWork(c, tgtObj).files == false {
+		log.Printf("INFO: unauthorized attempt by %s to check readiness of work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, fmt.Sprintf("access to %s is not authorized", workID))
+		return
+	}
+
+	work, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse work %s for readiness check: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, svc.checkReadiness(work.ETDWork, tgtObj.Files()))
+}
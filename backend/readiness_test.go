@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+)
+
+func TestRulesFor(t *testing.T) {
+	cfg := readinessConfig{
+		Rules: map[string]string{"title": readinessError, "keywords": readinessWarning, "pdf": readinessWarning},
+		Overrides: []readinessOverride{
+			{Program: "Creative Writing", Rules: map[string]string{"keywords": readinessOff}},
+			{Degree: "PHD", Rules: map[string]string{"pdf": readinessError}},
+			{Program: "Creative Writing", Degree: "MFA", Rules: map[string]string{"keywords": readinessError}},
+		},
+	}
+	tests := []struct {
+		name    string
+		program string
+		degree  string
+		want    map[string]string
+	}{
+		{name: "no override", program: "History", degree: "MA",
+			want: map[string]string{"title": readinessError, "keywords": readinessWarning, "pdf": readinessWarning}},
+		{name: "program override", program: "Creative Writing", degree: "MA",
+			want: map[string]string{"title": readinessError, "keywords": readinessOff, "pdf": readinessWarning}},
+		{name: "degree override is case insensitive", program: "History", degree: "phd",
+			want: map[string]string{"title": readinessError, "keywords": readinessWarning, "pdf": readinessError}},
+		{name: "later override wins", program: "Creative Writing", degree: "MFA",
+			want: map[string]string{"title": readinessError, "keywords": readinessError, "pdf": readinessWarning}},
+		{name: "blank program and degree", program: "", degree: "",
+			want: map[string]string{"title": readinessError, "keywords": readinessWarning, "pdf": readinessWarning}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := cfg.rulesFor(tc.program, tc.degree)
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("rulesFor(%q, %q) = %v; want %v", tc.program, tc.degree, got, tc.want)
+			}
+		})
+	}
+	if cfg.Rules["keywords"] != readinessWarning {
+		t.Errorf("rulesFor changed the base rules")
+	}
+}
+
+func TestCheckReadiness(t *testing.T) {
+	svc := serviceContext{Readiness: &readinessConfig{
+		Rules: map[string]string{"title": readinessError, "advisors": readinessError, "files": readinessError,
+			"pdf": readinessWarning, "keywords": readinessWarning, "language": readinessOff},
+		Overrides: []readinessOverride{{Program: "Creative Writing", Rules: map[string]string{"keywords": readinessOff}}},
+	}}
+	pdf := uvaeasystore.NewEasyStoreBlob("thesis.pdf", "application/pdf", nil)
+	img := uvaeasystore.NewEasyStoreBlob("figure.png", "image/png", nil)
+	advisor := librametadata.ContributorData{FirstName: "Ann", LastName: "Lee"}
+
+	tests := []struct {
+		name         string
+		work         librametadata.ETDWork
+		files        []uvaeasystore.EasyStoreBlob
+		wantReady    bool
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{name: "complete work",
+			work:      librametadata.ETDWork{Title: "Title", Advisors: []librametadata.ContributorData{advisor}, Keywords: []string{"k"}},
+			files:     []uvaeasystore.EasyStoreBlob{pdf},
+			wantReady: true, wantErrors: []string{}, wantWarnings: []string{}},
+		{name: "warnings do not block",
+			work:      librametadata.ETDWork{Title: "Title", Advisors: []librametadata.ContributorData{advisor}},
+			files:     []uvaeasystore.EasyStoreBlob{img},
+			wantReady: true, wantErrors: []string{}, wantWarnings: []string{"keywords", "pdf"}},
+		{name: "errors are sorted",
+			work:      librametadata.ETDWork{Title: "<p> </p>", Keywords: []string{" "}},
+			wantReady: false, wantErrors: []string{"advisors", "files", "title"}, wantWarnings: []string{"keywords", "pdf"}},
+		{name: "advisor missing a name",
+			work:      librametadata.ETDWork{Title: "Title", Advisors: []librametadata.ContributorData{{FirstName: "Ann"}}, Keywords: []string{"k"}},
+			files:     []uvaeasystore.EasyStoreBlob{pdf},
+			wantReady: false, wantErrors: []string{"advisors"}, wantWarnings: []string{}},
+		{name: "program override turns a rule off",
+			work:      librametadata.ETDWork{Title: "Title", Program: "Creative Writing", Advisors: []librametadata.ContributorData{advisor}},
+			files:     []uvaeasystore.EasyStoreBlob{pdf},
+			wantReady: true, wantErrors: []string{}, wantWarnings: []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := svc.checkReadiness(&tc.work, tc.files)
+			if got.Ready != tc.wantReady {
+				t.Errorf("checkReadiness ready = %t; want %t", got.Ready, tc.wantReady)
+			}
+			if rules := issueRules(got.Errors); reflect.DeepEqual(rules, tc.wantErrors) == false {
+				t.Errorf("checkReadiness errors = %v; want %v", rules, tc.wantErrors)
+			}
+			if rules := issueRules(got.Warnings); reflect.DeepEqual(rules, tc.wantWarnings) == false {
+				t.Errorf("checkReadiness warnings = %v; want %v", rules, tc.wantWarnings)
+			}
+		})
+	}
+}
+
+func issueRules(issues []readinessIssue) []string {
+	rules := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		rules = append(rules, issue.Rule)
+	}
+	return rules
+}
+
+func TestGetReadinessRequiresSignIn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{}
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/works/abc/readiness", nil)
+	c.Params = gin.Params{{Key: "id", Value: "abc"}}
+
+	svc.getReadiness(c)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous readiness request status = %d; want %d", resp.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registration imports are limited to keep user service lookups and work creation within a reasonable request time
+const maxImportRows = 500
+
+// columns expected in a registration import CSV
+var importColumns = []string{"computeID", "first", "last", "email", "program", "degree"}
+
+type importRow struct {
+	Row       int      `json:"row"`
+	ComputeID string   `json:"computeID"`
+	FirstName string   `json:"firstName"`
+	LastName  string   `json:"lastName"`
+	Email     string   `json:"email"`
+	Program   string   `json:"program"`
+	Degree    string   `json:"degree"`
+	WorkID    string   `json:"workID,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+type importResponse struct {
+	Committed      bool        `json:"committed"`
+	RegistrationID uint64      `json:"registrationID,omitempty"`
+	Program        string      `json:"program"`
+	Degree         string      `json:"degree"`
+	Valid          int         `json:"valid"`
+	Invalid        int         `json:"invalid"`
+	Rows           []importRow `json:"rows"`
+}
+
+// importRegistrations accepts a CSV of students for optional registration. Every row is validated and
+// returned as a preview; the registrations are only created when commit=true and no row has an error.
+func (svc *serviceContext) importRegistrations(c *gin.Context) {
+	claims := getJWTClaims(c)
+	commit, _ := strconv.ParseBool(c.Query("commit"))
+	log.Printf("INFO: %s requests registration import; commit [%t]", claims.ComputeID, commit)
+
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		log.Printf("INFO: registration import request has no file: %s", err.Error())
+		c.String(http.StatusBadRequest, "a csv file is required")
+		return
+	}
+	csvFile, err := formFile.Open()
+	if err != nil {
+		log.Printf("ERROR: unable to open registration import file: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer csvFile.Close()
+
+	rows, parseErr := parseImportCSV(csvFile)
+	if parseErr != nil {
+		log.Printf("INFO: invalid registration import file: %s", parseErr.Error())
+		c.String(http.StatusBadRequest, parseErr.Error())
+		return
+	}
+
+	resp, valErr := svc.validateImportRows(rows)
+	if valErr != nil {
+		log.Printf("ERROR: unable to validate registration import: %s", valErr.Error())
+		c.String(http.StatusInternalServerError, valErr.Error())
+		return
+	}
+	log.Printf("INFO: registration import has %d valid and %d invalid rows", resp.Valid, resp.Invalid)
+
+	if commit == false {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if resp.Invalid > 0 {
+		log.Printf("INFO: reject registration import commit with %d invalid rows", resp.Invalid)
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	regReq := registrationRequest{Program: resp.Program, Degree: resp.Degree}
+	for _, row := range resp.Rows {
+		regReq.Students = append(regReq.Students, studentRequest{
+			ComputeID: row.ComputeID,
+			Email:     row.Email,
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+		})
+	}
+	newReg, regErr := svc.createRegistrations(claims.auditActor(), &regReq)
+	if regErr != nil {
+		c.String(regErr.StatusCode, regErr.Message)
+		return
+	}
+	resp.Committed = true
+	resp.RegistrationID = newReg.ID
+	workIDs := make(map[string]string, len(newReg.Students))
+	for _, student := range newReg.Students {
+		workIDs[student.ComputeID] = student.WorkID
+	}
+	for i := range resp.Rows {
+		resp.Rows[i].WorkID = workIDs[resp.Rows[i].ComputeID]
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseImportCSV reads the rows of a registration import. A header row is optional.
+func parseImportCSV(src io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv: %s", err.Error())
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), importColumns[0]) {
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the csv file contains no students")
+	}
+	if len(records) > maxImportRows {
+		return nil, fmt.Errorf("the csv file contains %d students; the maximum is %d", len(records), maxImportRows)
+	}
+
+	rows := make([]importRow, 0, len(records))
+	for idx, rec := range records {
+		row := importRow{Row: idx + 1}
+		if len(rec) != len(importColumns) {
+			row.Errors = append(row.Errors, fmt.Sprintf("expected %d columns (%s) but found %d",
+				len(importColumns), strings.Join(importColumns, ", "), len(rec)))
+		}
+		cols := make([]string, len(importColumns))
+		for i := range cols {
+			if i < len(rec) {
+				cols[i] = strings.TrimSpace(rec[i])
+			}
+		}
+		row.ComputeID = strings.ToLower(cols[0])
+		row.FirstName = cols[1]
+		row.LastName = cols[2]
+		row.Email = cols[3]
+		row.Program = cols[4]
+		row.Degree = cols[5]
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// validateImportRows checks each row against the user service and the optional programs and degrees.
+// Names and email missing from a row are filled in from the user service. All rows must share one
+// program and degree because they are committed as a single registration.
+func (svc *serviceContext) validateImportRows(rows []importRow) (*importResponse, error) {
+	var programs []string
+	if err := svc.DB.Raw("select program from programs where type=?", "optional").Scan(&programs).Error; err != nil {
+		return nil, err
+	}
+	var degrees []string
+	if err := svc.DB.Raw("select degree from degrees where type=?", "optional").Scan(&degrees).Error; err != nil {
+		return nil, err
+	}
+	if err := svc.Protected.refreshJWT(svc.JWTKey); err != nil {
+		return nil, err
+	}
+
+	computeIDs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if row.ComputeID != "" {
+			computeIDs = append(computeIDs, row.ComputeID)
+		}
+	}
+	open, err := svc.findOpenRegistrations(computeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := importResponse{Rows: rows}
+	seen := make(map[string]int)
+	for i := range resp.Rows {
+		row := &resp.Rows[i]
+		if row.ComputeID == "" {
+			row.Errors = append(row.Errors, "compute id is required")
+		} else if prior, found := seen[row.ComputeID]; found {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s is a duplicate of row %d", row.ComputeID, prior))
+		} else {
+			seen[row.ComputeID] = row.Row
+			if rec, found := open[row.ComputeID]; found {
+				row.Errors = append(row.Errors, fmt.Sprintf("%s already has an open registration for work %s", row.ComputeID, rec.WorkID))
+			}
+			user, userErr := svc.getUserDetails(row.ComputeID)
+			if userErr != nil {
+				row.Errors = append(row.Errors, userErr.Message)
+			} else {
+				if row.FirstName == "" {
+					row.FirstName = user.FirstName
+				}
+				if row.LastName == "" {
+					row.LastName = user.LastName
+				}
+				if row.Email == "" {
+					row.Email = user.Email
+				}
+			}
+		}
+		if row.FirstName == "" || row.LastName == "" {
+			row.Errors = append(row.Errors, "first and last name are required")
+		}
+
+		if row.Program == "" {
+			row.Errors = append(row.Errors, "program is required")
+		} else if canonical := matchName(programs, row.Program); canonical == "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s is not an optional program", row.Program))
+		} else {
+			row.Program = canonical
+			if resp.Program == "" {
+				resp.Program = canonical
+			} else if resp.Program != canonical {
+				row.Errors = append(row.Errors, fmt.Sprintf("program %s does not match %s from earlier rows", canonical, resp.Program))
+			}
+		}
+
+		if row.Degree == "" {
+			row.Errors = append(row.Errors, "degree is required")
+		} else if canonical := matchName(degrees, row.Degree); canonical == "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s is not an optional degree", row.Degree))
+		} else {
+			row.Degree = canonical
+			if resp.Degree == "" {
+				resp.Degree = canonical
+			} else if resp.Degree != canonical {
+				row.Errors = append(row.Errors, fmt.Sprintf("degree %s does not match %s from earlier rows", canonical, resp.Degree))
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			resp.Invalid++
+		} else {
+			resp.Valid++
+		}
+	}
+	return &resp, nil
+}
+
+// matchName returns the value from the list that matches the name ignoring case, or blank if there is no match
+func matchName(values []string, name string) string {
+	for _, v := range values {
+		if strings.EqualFold(v, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	tooMany := strings.Repeat("mst3k,Mary,Smith,,History,MA\n", maxImportRows+1)
+	tests := []struct {
+		name    string
+		csv     string
+		want    []importRow
+		wantErr string
+	}{
+		{name: "with header",
+			csv:  "computeID,first,last,email,program,degree\nmst3k,Mary,Smith,mst3k@virginia.edu,History,MA\n",
+			want: []importRow{{Row: 1, ComputeID: "mst3k", FirstName: "Mary", LastName: "Smith", Email: "mst3k@virginia.edu", Program: "History", Degree: "MA"}}},
+		{name: "without header and values trimmed",
+			csv: " MST3K , Mary,Smith,,History, MA\nabc1d,Al,Bo,,History,MA",
+			want: []importRow{
+				{Row: 1, ComputeID: "mst3k", FirstName: "Mary", LastName: "Smith", Program: "History", Degree: "MA"},
+				{Row: 2, ComputeID: "abc1d", FirstName: "Al", LastName: "Bo", Program: "History", Degree: "MA"},
+			}},
+		{name: "header is case insensitive",
+			csv:  "ComputeId,First,Last,Email,Program,Degree\nmst3k,Mary,Smith,,History,MA\n",
+			want: []importRow{{Row: 1, ComputeID: "mst3k", FirstName: "Mary", LastName: "Smith", Program: "History", Degree: "MA"}}},
+		{name: "wrong column count is a row error",
+			csv: "mst3k,Mary,Smith\n",
+			want: []importRow{{Row: 1, ComputeID: "mst3k", FirstName: "Mary", LastName: "Smith",
+				Errors: []string{"expected 6 columns (computeID, first, last, email, program, degree) but found 3"}}}},
+		{name: "header only", csv: "computeID,first,last,email,program,degree\n", wantErr: "the csv file contains no students"},
+		{name: "empty", csv: "", wantErr: "the csv file contains no students"},
+		{name: "unterminated quote", csv: "\"mst3k,Mary,Smith,,History,MA\n", wantErr: "unable to read csv"},
+		{name: "too many rows", csv: tooMany,
+			wantErr: fmt.Sprintf("the csv file contains %d students; the maximum is %d", maxImportRows+1, maxImportRows)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseImportCSV(strings.NewReader(tc.csv))
+			if tc.wantErr != "" {
+				if err == nil || strings.HasPrefix(err.Error(), tc.wantErr) == false {
+					t.Fatalf("parseImportCSV error = %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImportCSV failed: %s", err.Error())
+			}
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("parseImportCSV = %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportRegistrationsRejectsBadFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{}
+	registrar := jwtClaims{UserDetails: &UserDetails{ComputeID: "reg1", Role: "registrar"}}
+
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Set("claims", registrar)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/registrar/register/import", strings.NewReader(""))
+	svc.importRegistrations(c)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("import without a file status = %d; want %d", resp.Code, http.StatusBadRequest)
+	}
+
+	var body bytes.Buffer
+	mpWriter := multipart.NewWriter(&body)
+	part, _ := mpWriter.CreateFormFile("file", "students.csv")
+	part.Write([]byte("computeID,first,last,email,program,degree\n"))
+	mpWriter.Close()
+	resp = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(resp)
+	c.Set("claims", registrar)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/registrar/register/import", &body)
+	c.Request.Header.Set("Content-Type", mpWriter.FormDataContentType())
+	svc.importRegistrations(c)
+	if resp.Code != http.StatusBadRequest || resp.Body.String() != "the csv file contains no students" {
+		t.Errorf("import of an empty csv = %d %q; want a 400 for no students", resp.Code, resp.Body.String())
+	}
+}
@@ -2,15 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/uvalib/easystore/uvaeasystore"
 	librametadata "github.com/uvalib/libra-metadata"
+	"gorm.io/gorm"
 )
 
 type registrationStudent struct {
@@ -19,6 +22,8 @@ type registrationStudent struct {
 	WorkID         string     `json:"workID"`
 	ComputeID      string     `json:"computeID"`
 	CompletedAt    *time.Time `json:"completedAt"`
+	CancelledAt    *time.Time `json:"cancelledAt,omitempty"`
+	CancelledBy    string     `json:"cancelledBy,omitempty"`
 }
 
 type registration struct {
@@ -182,61 +187,205 @@ func (svc *serviceContext) submitOptionalRegistrations(c *gin.Context) {
 	claims := getJWTClaims(c)
 	log.Printf("INFO: %s requests optional registrations %+v", claims.ComputeID, regReq)
 
-	log.Printf("INFO: create registration record to track status")
+	newReg, regErr := svc.createRegistrations(claims.auditActor(), &regReq)
+	if regErr != nil {
+		c.String(regErr.StatusCode, regErr.Message)
+		return
+	}
+	log.Printf("INFO: registration %d created with %d students", newReg.ID, len(newReg.Students))
+	c.JSON(http.StatusOK, newReg)
+}
+
+// findOpenRegistrations returns the open registration for any of the compute IDs, keyed by compute ID.
+// A registration is open until the student publishes the registered work or the registration is cancelled.
+// Registrations left open for works that were deleted before deletes cancelled them are cancelled here.
+func (svc *serviceContext) findOpenRegistrations(computeIDs []string) (map[string]registrationStudent, error) {
+	open := make(map[string]registrationStudent)
+	if len(computeIDs) == 0 {
+		return open, nil
+	}
+	var existing []registrationStudent
+	if err := svc.DB.Where("compute_id in ? and completed_at is null and cancelled_at is null", computeIDs).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	for _, rec := range existing {
+		regObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, rec.WorkID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+		if err != nil && strings.Contains(err.Error(), "not exist") == false {
+			return nil, err
+		}
+		if err != nil || isTrashed(regObj) {
+			log.Printf("INFO: work %s registered to %s has been deleted; cancel the registration", rec.WorkID, rec.ComputeID)
+			if err := svc.cancelWorkRegistration(systemActor, rec.WorkID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		open[rec.ComputeID] = rec
+	}
+	return open, nil
+}
+
+// cancelWorkRegistration cancels the open registration of a work that has been deleted, so the student
+// can be registered again
+func (svc *serviceContext) cancelWorkRegistration(actor auditActor, workID string) error {
+	resp := svc.DB.Model(&registrationStudent{}).Where("work_id=? and completed_at is null and cancelled_at is null", workID).
+		Updates(map[string]any{"cancelled_at": time.Now(), "cancelled_by": actor.computeID})
+	if resp.Error != nil {
+		return fmt.Errorf("unable to cancel registration for work %s: %w", workID, resp.Error)
+	}
+	if resp.RowsAffected > 0 {
+		svc.auditRegistration(actor, workID, "open", "cancelled")
+	}
+	return nil
+}
+
+// reopenWorkRegistration reopens the cancelled registration of a restored draft. The registration stays
+// cancelled if the student has been registered again since the work was deleted.
+func (svc *serviceContext) reopenWorkRegistration(actor auditActor, workID string) error {
+	resp := svc.DB.Model(&registrationStudent{}).Where("work_id=? and completed_at is null and cancelled_at is not null", workID).
+		Updates(map[string]any{"cancelled_at": nil, "cancelled_by": ""})
+	if errors.Is(resp.Error, gorm.ErrDuplicatedKey) {
+		log.Printf("INFO: the student of restored work %s has another open registration; registration stays cancelled", workID)
+		return nil
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("unable to reopen registration for work %s: %w", workID, resp.Error)
+	}
+	if resp.RowsAffected > 0 {
+		svc.auditRegistration(actor, workID, "cancelled", "open")
+	}
+	return nil
+}
+
+// createRegistrations creates a registration record and a draft work for each student in the request,
+// then sends each student an invitation to deposit. Creation is all or nothing; if any step fails the
+// database changes are rolled back and any works already created are deleted.
+func (svc *serviceContext) createRegistrations(actor auditActor, regReq *registrationRequest) (*registration, *RequestError) {
+	if len(regReq.Students) == 0 {
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: "at least one student is required"}
+	}
+	computeIDs := make([]string, 0, len(regReq.Students))
+	requested := make(map[string]bool)
+	for _, student := range regReq.Students {
+		if student.ComputeID == "" {
+			return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: "all students require a compute id"}
+		}
+		if requested[student.ComputeID] {
+			return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("%s is listed more than once", student.ComputeID)}
+		}
+		requested[student.ComputeID] = true
+		computeIDs = append(computeIDs, student.ComputeID)
+	}
+
+	open, err := svc.findOpenRegistrations(computeIDs)
+	if err != nil {
+		log.Printf("ERROR: unable to check for existing registrations: %s", err.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if len(open) > 0 {
+		dups := make([]string, 0, len(open))
+		for _, cid := range computeIDs {
+			if rec, found := open[cid]; found {
+				dups = append(dups, fmt.Sprintf("%s (work %s)", cid, rec.WorkID))
+			}
+		}
+		log.Printf("INFO: reject registration of students with open registrations: %s", strings.Join(dups, ", "))
+		return nil, &RequestError{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("students already have an open registration: %s", strings.Join(dups, ", "))}
+	}
+
 	newRegistration := registration{
-		Registrar:   claims.ComputeID,
+		Registrar:   actor.computeID,
 		Degree:      regReq.Degree,
 		Program:     regReq.Program,
 		SubmittedAt: time.Now(),
 	}
-	if err := svc.DB.Create(&newRegistration).Error; err != nil {
-		log.Printf("ERROR: unable to create registration record: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	for _, student := range regReq.Students {
-		author := librametadata.ContributorData{ComputeID: student.ComputeID,
-			FirstName: student.FirstName, LastName: student.LastName, Institution: "University of Virginia"}
-		etdReg := librametadata.ETDWork{Program: regReq.Program, Degree: regReq.Degree, Author: author}
-		obj := uvaeasystore.NewEasyStoreObject(svc.Namespace, "")
-		fields := uvaeasystore.DefaultEasyStoreFields()
-		fields["create-date"] = time.Now().UTC().Format(svc.TimeFormat)
-		fields["draft"] = "true"
-		fields["default-visibility"] = ""
-		fields["depositor"] = student.ComputeID
-		fields["registrar"] = claims.ComputeID
-		fields["source"] = "optional"
-		obj.SetFields(fields)
-
-		// An ETDWork does not serialize the same way as an EasyStoreMetadata object
-		// does when being managed by json.Marshal/json.Unmarshal so we wrap it in an object that
-		// behaves appropriately
-		pl, err := etdReg.Payload()
-		if err != nil {
-			log.Printf("ERROR: serializing ETDWork: %s", err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
+	created := make([]uvaeasystore.EasyStoreObject, 0, len(regReq.Students))
+	txErr := svc.DB.Transaction(func(tx *gorm.DB) error {
+		log.Printf("INFO: create registration record to track status")
+		if err := tx.Omit("Students").Create(&newRegistration).Error; err != nil {
+			return fmt.Errorf("unable to create registration record: %s", err.Error())
 		}
-		obj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(etdReg.MimeType(), pl))
 
-		_, err = svc.EasyStore.ObjectCreate(obj)
-		if err != nil {
-			log.Printf("ERROR: admin create registration failed: %s", err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
+		for _, student := range regReq.Students {
+			author := librametadata.ContributorData{ComputeID: student.ComputeID,
+				FirstName: student.FirstName, LastName: student.LastName, Institution: "University of Virginia"}
+			etdReg := librametadata.ETDWork{Program: regReq.Program, Degree: regReq.Degree, Author: author}
+			obj := uvaeasystore.NewEasyStoreObject(svc.Namespace, "")
+			fields := uvaeasystore.DefaultEasyStoreFields()
+			fields["create-date"] = time.Now().UTC().Format(svc.TimeFormat)
+			fields["draft"] = "true"
+			fields["default-visibility"] = ""
+			fields["depositor"] = student.ComputeID
+			fields["registrar"] = actor.computeID
+			fields["source"] = "optional"
+			obj.SetFields(fields)
+
+			// An ETDWork does not serialize the same way as an EasyStoreMetadata object
+			// does when being managed by json.Marshal/json.Unmarshal so we wrap it in an object that
+			// behaves appropriately
+			pl, err := etdReg.Payload()
+			if err != nil {
+				return fmt.Errorf("unable to serialize work for %s: %s", student.ComputeID, err.Error())
+			}
+			obj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(etdReg.MimeType(), pl))
+
+			newObj, err := svc.EasyStore.ObjectCreate(obj)
+			if err != nil {
+				return fmt.Errorf("unable to create work for %s: %s", student.ComputeID, err.Error())
+			}
+			created = append(created, newObj)
+
+			log.Printf("INFO: add new work %s to registrations record %d", newObj.Id(), newRegistration.ID)
+			rec := registrationStudent{
+				RegistrationID: newRegistration.ID,
+				WorkID:         newObj.Id(),
+				ComputeID:      student.ComputeID,
+			}
+			if err := tx.Create(&rec).Error; err != nil {
+				return fmt.Errorf("unable to create registration record for %s work %s: %w", student.ComputeID, newObj.Id(), err)
+			}
+			newRegistration.Students = append(newRegistration.Students, rec)
 		}
+		return nil
+	})
 
-		log.Printf("INFO: add new work to registrations record %d", newRegistration.ID)
-		rec := registrationStudent{
-			RegistrationID: newRegistration.ID,
-			WorkID:         obj.Id(),
-			ComputeID:      student.ComputeID,
+	if txErr != nil {
+		log.Printf("ERROR: registration failed; %d works will be removed: %s", len(created), txErr.Error())
+		for _, obj := range created {
+			if _, err := svc.EasyStore.ObjectDelete(obj, uvaeasystore.AllComponents); err != nil {
+				log.Printf("ERROR: unable to remove work %s from failed registration: %s", obj.Id(), err.Error())
+			}
 		}
-		if err := svc.DB.Create(&rec).Error; err != nil {
-			log.Printf("ERROR: unable to create student %s work %s registration record for registration %d: %s",
-				student.ComputeID, obj.Id(), newRegistration.ID, err.Error())
+		// a registration opened for the same student by another request after the check above
+		if errors.Is(txErr, gorm.ErrDuplicatedKey) {
+			return nil, &RequestError{StatusCode: http.StatusConflict, Message: "students already have an open registration"}
 		}
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: txErr.Error()}
+	}
+
+	// audits and invitations are only sent once every registration is in place
+	for idx, student := range regReq.Students {
+		svc.auditRegistration(actor, newRegistration.Students[idx].WorkID, "", "open")
+		svc.sendInvitation(actor, newRegistration.Students[idx].WorkID, student, regReq.Program, regReq.Degree)
+	}
+	return &newRegistration, nil
+}
+
+// sendInvitation emails a student an invitation to deposit their registered work
+func (svc *serviceContext) sendInvitation(actor auditActor, workID string, student studentRequest, program, degree string) {
+	invite := emailData{
+		FirstName: student.FirstName,
+		LastName:  student.LastName,
+		Degree:    degree,
+		Program:   program,
+		WorkURL:   fmt.Sprintf("%s/etd/%s", svc.EtdURL, workID),
+	}
+	recipient := student.Email
+	if recipient == "" {
+		recipient = fmt.Sprintf("%s@virginia.edu", student.ComputeID)
+	}
+	if err := svc.sendNotification(actor, workID, invitationNotice, recipient, invite); err != nil {
+		log.Printf("ERROR: unable to send deposit invitation for work %s: %s", workID, err.Error())
 	}
-	c.String(http.StatusOK, fmt.Sprintf("%d registrations completed", len(claims.ComputeID)))
 }
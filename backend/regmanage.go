@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+)
+
+type registrationChangeRequest struct {
+	Program string `json:"program"`
+	Degree  string `json:"degree"`
+	Version string `json:"version"`
+}
+
+type registrationTransferRequest struct {
+	ComputeID string `json:"computeID"`
+	Email     string `json:"email"`
+	Version   string `json:"version"`
+}
+
+// getOpenRegistration loads the registration record and draft work for the work ID param. The response
+// has already been sent and nil is returned if the registration cannot be changed by the current user.
+func (svc *serviceContext) getOpenRegistration(c *gin.Context, components uvaeasystore.EasyStoreComponents) (*registrationStudent, uvaeasystore.EasyStoreObject) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+
+	var regRec registrationStudent
+	resp := svc.DB.Where("work_id=?", workID).Limit(1).Find(&regRec)
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to get registration for work %s: %s", workID, resp.Error.Error())
+		c.String(http.StatusInternalServerError, resp.Error.Error())
+		return nil, nil
+	}
+	if resp.RowsAffected == 0 {
+		log.Printf("INFO: work %s has no registration", workID)
+		c.String(http.StatusNotFound, fmt.Sprintf("%s is not a registered work", workID))
+		return nil, nil
+	}
+	if regRec.CancelledAt != nil {
+		log.Printf("INFO: registration for work %s has been cancelled", workID)
+		c.String(http.StatusGone, fmt.Sprintf("the registration for %s has been cancelled", workID))
+		return nil, nil
+	}
+	if regRec.CompletedAt != nil {
+		log.Printf("INFO: registration for work %s has been completed", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s has already been submitted", workID))
+		return nil, nil
+	}
+
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, components)
+	if err != nil {
+		log.Printf("ERROR: unable to get registered work %s: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return nil, nil
+	}
+	fields := tgtObj.Fields()
+	if claims.isAdmin() == false && fields["registrar"] != claims.ComputeID {
+		log.Printf("INFO: registrar %s attempted to change registration %s created by %s", claims.ComputeID, workID, fields["registrar"])
+		c.String(http.StatusForbidden, "only the registrar that created this registration can change it")
+		return nil, nil
+	}
+	if fields["draft"] != "true" || isTrashed(tgtObj) {
+		log.Printf("INFO: registered work %s is no longer an open draft", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s is no longer a draft", workID))
+		return nil, nil
+	}
+	return &regRec, tgtObj
+}
+
+func (svc *serviceContext) auditRegistration(actor auditActor, workID string, before, after string) {
+	auditEvt := uvalibrabus.UvaAuditEvent{
+		Who:       actor.computeID,
+		FieldName: "registration",
+		Before:    before,
+		After:     after,
+	}
+	svc.publishAuditEvent(actor, svc.Namespace, workID, auditEvt)
+}
+
+// cancelRegistration withdraws a registration. The draft is moved to the trash so it can be restored if the
+// cancel was a mistake; the trash purge removes it along with its collaborators and version history.
+func (svc *serviceContext) cancelRegistration(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	log.Printf("INFO: %s requests cancel of registration for work %s", claims.ComputeID, workID)
+	regRec, tgtObj := svc.getOpenRegistration(c, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if regRec == nil {
+		return
+	}
+
+	// the registration of a draft is cancelled when it is moved to the trash
+	log.Printf("INFO: move registered work %s to the trash", workID)
+	if err := svc.moveToTrash(claims.auditActor(), tgtObj); err != nil {
+		log.Printf("ERROR: unable to move registered work %s to the trash: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+
+	now := time.Now()
+	regRec.CancelledAt = &now
+	regRec.CancelledBy = claims.ComputeID
+	c.JSON(http.StatusOK, regRec)
+}
+
+// updateRegistration changes the program and degree of a registered draft
+func (svc *serviceContext) updateRegistration(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	var chgReq registrationChangeRequest
+	if err := c.ShouldBindJSON(&chgReq); err != nil {
+		log.Printf("ERROR: bad payload for registration change: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("INFO: %s requests registration for work %s change to %+v", claims.ComputeID, workID, chgReq)
+
+	if chgReq.Program == "" && chgReq.Degree == "" {
+		c.String(http.StatusBadRequest, "program or degree is required")
+		return
+	}
+	if chgReq.Program != "" && svc.isOptionalValue("programs", "program", chgReq.Program) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not an optional program", chgReq.Program))
+		return
+	}
+	if chgReq.Degree != "" && svc.isOptionalValue("degrees", "degree", chgReq.Degree) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not an optional degree", chgReq.Degree))
+		return
+	}
+
+	regRec, tgtObj := svc.getOpenRegistration(c, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if regRec == nil {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, chgReq.Version) == false {
+		return
+	}
+
+	origWork, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse registered work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	updatedWork := *origWork.ETDWork
+	if chgReq.Program != "" {
+		updatedWork.Program = chgReq.Program
+	}
+	if chgReq.Degree != "" {
+		updatedWork.Degree = chgReq.Degree
+	}
+
+	updatedObj, err := svc.saveWorkChange(claims.auditActor(), tgtObj, *origWork.ETDWork, updatedWork, nil)
+	if err != nil {
+		log.Printf("ERROR: unable to update registered work %s: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+	c.Header("ETag", fmt.Sprintf("\"%s\"", updatedObj.VTag()))
+	c.String(http.StatusOK, "updated")
+}
+
+// transferRegistration assigns a registered draft to a different student, typically to correct a
+// mistyped compute ID. The new student is sent an invitation.
+func (svc *serviceContext) transferRegistration(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	var xferReq registrationTransferRequest
+	if err := c.ShouldBindJSON(&xferReq); err != nil {
+		log.Printf("ERROR: bad payload for registration transfer: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	xferReq.ComputeID = strings.ToLower(strings.TrimSpace(xferReq.ComputeID))
+	log.Printf("INFO: %s requests registration for work %s transfer to %s", claims.ComputeID, workID, xferReq.ComputeID)
+	if xferReq.ComputeID == "" {
+		c.String(http.StatusBadRequest, "compute id is required")
+		return
+	}
+
+	regRec, tgtObj := svc.getOpenRegistration(c, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if regRec == nil {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, xferReq.Version) == false {
+		return
+	}
+	if regRec.ComputeID == xferReq.ComputeID {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is already registered to %s", workID, xferReq.ComputeID))
+		return
+	}
+
+	open, err := svc.findOpenRegistrations([]string{xferReq.ComputeID})
+	if err != nil {
+		log.Printf("ERROR: unable to check for existing registrations: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rec, found := open[xferReq.ComputeID]; found {
+		c.String(http.StatusConflict, fmt.Sprintf("%s already has an open registration for work %s", xferReq.ComputeID, rec.WorkID))
+		return
+	}
+
+	if err := svc.Protected.refreshJWT(svc.JWTKey); err != nil {
+		log.Printf("ERROR: unable to refresh protected services jwt: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, userErr := svc.getUserDetails(xferReq.ComputeID)
+	if userErr != nil {
+		log.Printf("INFO: unable to transfer work %s to %s: %s", workID, xferReq.ComputeID, userErr.Message)
+		c.String(userErr.StatusCode, userErr.Message)
+		return
+	}
+
+	origWork, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse registered work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	updatedWork := *origWork.ETDWork
+	updatedWork.Author.ComputeID = user.ComputeID
+	updatedWork.Author.FirstName = user.FirstName
+	updatedWork.Author.LastName = user.LastName
+
+	origComputeID := regRec.ComputeID
+	updatedObj, err := svc.saveWorkChange(claims.auditActor(), tgtObj, *origWork.ETDWork, updatedWork, &user.ComputeID)
+	if err != nil {
+		log.Printf("ERROR: unable to transfer registered work %s: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+
+	// access granted by or to the mistyped student does not carry over to the new one
+	var staleCollabs []workCollaborator
+	if err := svc.DB.Where("work_id=? and (compute_id=? or added_by=?)", workID, origComputeID, origComputeID).Find(&staleCollabs).Error; err != nil {
+		log.Printf("ERROR: unable to get collaborators of work %s added by %s: %s", workID, origComputeID, err.Error())
+	}
+	for _, collab := range staleCollabs {
+		if err := svc.DB.Delete(&collab).Error; err != nil {
+			log.Printf("ERROR: unable to remove collaborator %s from work %s: %s", collab.ComputeID, workID, err.Error())
+			continue
+		}
+		svc.publishAuditEvent(claims.auditActor(), svc.Namespace, workID, auditCollaborator(claims.ComputeID, &collab, nil))
+	}
+
+	regRec.ComputeID = user.ComputeID
+	if err := svc.DB.Model(regRec).Select("ComputeID").Updates(*regRec).Error; err != nil {
+		log.Printf("ERROR: unable to update registration for work %s to %s: %s", workID, user.ComputeID, err.Error())
+	}
+
+	student := studentRequest{ComputeID: user.ComputeID, Email: xferReq.Email, FirstName: user.FirstName, LastName: user.LastName}
+	if student.Email == "" {
+		student.Email = user.Email
+	}
+	svc.sendInvitation(claims.auditActor(), workID, student, updatedWork.Program, updatedWork.Degree)
+	c.Header("ETag", fmt.Sprintf("\"%s\"", updatedObj.VTag()))
+	c.JSON(http.StatusOK, regRec)
+}
+
+// resendInvitation sends the deposit invitation for a registered draft again
+func (svc *serviceContext) resendInvitation(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	log.Printf("INFO: %s requests invitation for work %s be sent again", claims.ComputeID, workID)
+	regRec, tgtObj := svc.getOpenRegistration(c, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if regRec == nil {
+		return
+	}
+	work, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse registered work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	student := studentRequest{ComputeID: regRec.ComputeID, FirstName: work.Author.FirstName, LastName: work.Author.LastName}
+	svc.sendInvitation(claims.auditActor(), workID, student, work.Program, work.Degree)
+	c.String(http.StatusOK, "sent")
+}
+
+// saveWorkChange saves a change to the metadata of a work, and optionally its depositor, with audits and
+// a new version. The saved work is reloaded and returned.
+func (svc *serviceContext) saveWorkChange(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, origWork, updatedWork librametadata.ETDWork, newDepositor *string) (uvaeasystore.EasyStoreObject, error) {
+	pl, err := updatedWork.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize work: %s", err.Error())
+	}
+	svc.recordBaseline(tgtObj)
+	tgtObj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(updatedWork.MimeType(), pl))
+
+	fields := tgtObj.Fields()
+	origDepositor := fields["depositor"]
+	fields["modify-date"] = time.Now().UTC().Format(svc.TimeFormat)
+	if newDepositor != nil {
+		fields["depositor"] = *newDepositor
+	}
+	tgtObj.SetFields(fields)
+
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields|uvaeasystore.Metadata); err != nil {
+		return nil, err
+	}
+
+	auditCtx := svc.newAuditContext(actor, svc.Namespace, tgtObj.Id())
+	svc.auditWork(auditCtx, origWork, updatedWork)
+	if newDepositor != nil && origDepositor != *newDepositor {
+		auditEvt := uvalibrabus.UvaAuditEvent{
+			Who:       actor.computeID,
+			FieldName: "depositor",
+			Before:    origDepositor,
+			After:     *newDepositor,
+		}
+		svc.publishAuditChange(auditCtx, auditEvt)
+	}
+	svc.publishEvent(uvalibrabus.EventObjectUpdate, svc.Namespace, tgtObj.Id())
+
+	updatedObj, err := svc.EasyStore.ObjectGetByKey(tgtObj.Namespace(), tgtObj.Id(), uvaeasystore.AllComponents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get updated work: %s", err.Error())
+	}
+	if _, err := svc.recordVersion(actor.computeID, updatedObj, "", time.Now()); err != nil {
+		log.Printf("ERROR: unable to record version of work %s: %s", tgtObj.Id(), err.Error())
+	}
+	return updatedObj, nil
+}
+
+// isOptionalValue returns true if the value is an optional program or degree
+func (svc *serviceContext) isOptionalValue(table, column, value string) bool {
+	var cnt int64
+	err := svc.DB.Table(table).Where(fmt.Sprintf("type=? and %s=?", column), "optional", value).Count(&cnt).Error
+	if err != nil {
+		log.Printf("ERROR: unable to check for optional %s %s: %s", column, value, err.Error())
+		return false
+	}
+	return cnt > 0
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm/clause"
+)
+
+// advisor review states stored in the review-status field of a work. Programs flagged with
+// review_required cannot publish a work until an advisor has approved it.
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewChanges  = "changes-requested"
+)
+
+// workReview is a decision recorded by an advisor
+type workReview struct {
+	ID        uint64    `json:"-"`
+	WorkID    string    `json:"-"`
+	ComputeID string    `json:"computeID"`
+	Decision  string    `json:"decision"`
+	Comments  string    `json:"comments"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// reviewDetails is the review state included in the work JSON
+type reviewDetails struct {
+	Required    bool         `json:"required"`
+	Status      string       `json:"status,omitempty"`
+	RequestedAt string       `json:"requestedAt,omitempty"`
+	DecidedAt   string       `json:"decidedAt,omitempty"`
+	Decisions   []workReview `json:"decisions,omitempty"`
+}
+
+type reviewDecisionRequest struct {
+	versionRequest
+	Decision string `json:"decision"`
+	Comments string `json:"comments"`
+}
+
+var (
+	reviewRequestNotice  = notification{Template: "review.txt", Subject: "A thesis is ready for your review in Libra", StampField: "review-notice-sent"}
+	reviewDecisionNotice = notification{Template: "review-decision.txt", Subject: "Your advisor has reviewed your thesis"}
+)
+
+// isReviewRequired returns true if works in the program must be approved by an advisor before publication
+func (svc *serviceContext) isReviewRequired(programName string) (bool, error) {
+	if programName == "" {
+		return false, nil
+	}
+	var required []bool
+	if err := svc.DB.Raw("select review_required from programs where program=?", programName).Scan(&required).Error; err != nil {
+		return false, fmt.Errorf("unable to check review requirement for program %s: %w", programName, err)
+	}
+	return slices.Contains(required, true), nil
+}
+
+// getReviewDetails builds the review state of a draft work, including the advisor decisions made so far
+func (svc *serviceContext) getReviewDetails(tgtObj uvaeasystore.EasyStoreObject, work *workDetails) *reviewDetails {
+	fields := tgtObj.Fields()
+	required, err := svc.isReviewRequired(work.Program)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	resp := reviewDetails{
+		Required:    required,
+		Status:      fields["review-status"],
+		RequestedAt: fields["review-requested"],
+		DecidedAt:   fields["review-date"],
+	}
+	if resp.Required == false && resp.Status == "" {
+		return nil
+	}
+	if err := svc.DB.Where("work_id=?", tgtObj.Id()).Order("created_at asc").Find(&resp.Decisions).Error; err != nil {
+		log.Printf("ERROR: unable to get review decisions for work %s: %s", tgtObj.Id(), err.Error())
+	}
+	return &resp
+}
+
+// advisorComputeIDs returns the distinct, non-blank advisor compute IDs of a work
+func advisorComputeIDs(work *librametadata.ETDWork) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, advisor := range work.Advisors {
+		cid := strings.ToLower(strings.TrimSpace(advisor.ComputeID))
+		if cid == "" || seen[cid] {
+			continue
+		}
+		seen[cid] = true
+		out = append(out, cid)
+	}
+	return out
+}
+
+// reviewAdvisors returns the advisors that were asked to review the work when it was last submitted
+func reviewAdvisors(tgtObj uvaeasystore.EasyStoreObject) []string {
+	out := make([]string, 0)
+	for _, cid := range strings.Split(tgtObj.Fields()["review-advisors"], ",") {
+		if cid != "" {
+			out = append(out, cid)
+		}
+	}
+	return out
+}
+
+// clearReview removes the review state from the fields of a work so it must be submitted for review again
+func clearReview(fields uvaeasystore.EasyStoreObjectFields) {
+	delete(fields, "review-status")
+	delete(fields, "review-requested")
+	delete(fields, "review-advisors")
+	delete(fields, "review-date")
+}
+
+// clearApprovalForFileChange withdraws the advisor approval of a draft before a non-admin changes its files,
+// since the approval only covers the files that were reviewed. It is called before the file change so that
+// a failed save can never leave the approval in place for files that were not reviewed.
+func (svc *serviceContext) clearApprovalForFileChange(claims *jwtClaims, tgtObj uvaeasystore.EasyStoreObject) error {
+	fields := tgtObj.Fields()
+	if fields["review-status"] != reviewApproved || fields["draft"] != "true" || claims.isAdmin() {
+		return nil
+	}
+	log.Printf("INFO: files of work %s changed after advisor approval; review is required again", tgtObj.Id())
+	clearReview(fields)
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		return fmt.Errorf("unable to clear review of work %s: %w", tgtObj.Id(), err)
+	}
+	svc.auditReviewStatus(claims.auditActor(), tgtObj.Id(), reviewApproved, "")
+	return nil
+}
+
+func (svc *serviceContext) auditReviewStatus(actor auditActor, workID string, before, after string) {
+	auditEvt := uvalibrabus.UvaAuditEvent{
+		Who:       actor.computeID,
+		FieldName: "review-status",
+		Before:    before,
+		After:     after,
+	}
+	svc.publishAuditEvent(actor, svc.Namespace, workID, auditEvt)
+}
+
+// getReviewWork loads the draft work for the ID param. The response has already been sent and nil is
+// returned if the work cannot be loaded or is no longer an open draft.
+func (svc *serviceContext) getReviewWork(c *gin.Context) (uvaeasystore.EasyStoreObject, *workDetails) {
+	workID := c.Param("id")
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for review: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return nil, nil
+	}
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject review of deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return nil, nil
+	}
+	if tgtObj.Fields()["draft"] == "false" {
+		log.Printf("INFO: reject review of published work %s", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s is already published", workID))
+		return nil, nil
+	}
+	work, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse work %s for review: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, nil
+	}
+	return tgtObj, work
+}
+
+// submitForReview is called by the depositor to send a draft to the advisors listed on the work. Each
+// advisor is given view access to the draft and emailed a link to review it.
+func (svc *serviceContext) submitForReview(c *gin.Context) {
+	claims := getJWTClaims(c)
+	tgtObj, work := svc.getReviewWork(c)
+	if tgtObj == nil {
+		return
+	}
+	workID := tgtObj.Id()
+	log.Printf("INFO: %s requests advisor review of work %s", claims.ComputeID, workID)
+	fields := tgtObj.Fields()
+	if (claims.ComputeID == fields["depositor"] || claims.isAdmin()) == false {
+		log.Printf("INFO: unauthorized attempt by %s to submit work %s for review", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "only the depositor can submit this work for review")
+		return
+	}
+
+	var verReq versionRequest
+	if bindOptionalJSON(c, &verReq) == false {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, verReq.Version) == false {
+		return
+	}
+
+	required, err := svc.isReviewRequired(work.Program)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if required == false {
+		log.Printf("INFO: program %s of work %s does not require advisor review", work.Program, workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s does not require advisor review", work.Program))
+		return
+	}
+	if fields["review-status"] == reviewPending || fields["review-status"] == reviewApproved {
+		log.Printf("INFO: work %s is already %s", workID, fields["review-status"])
+		c.String(http.StatusConflict, fmt.Sprintf("review of %s is already %s", workID, fields["review-status"]))
+		return
+	}
+	// the depositor and edit collaborators control the advisor list, so they can never review the work
+	advisors := make([]string, 0)
+	for _, advisor := range advisorComputeIDs(work.ETDWork) {
+		if svc.isWorkEditor(advisor, tgtObj) {
+			log.Printf("INFO: %s is an editor of work %s and cannot review it", advisor, workID)
+			continue
+		}
+		advisors = append(advisors, advisor)
+	}
+	if len(advisors) == 0 {
+		log.Printf("INFO: work %s has no advisors with a compute id", workID)
+		c.String(http.StatusUnprocessableEntity, "at least one advisor with a UVA computing id is required for review")
+		return
+	}
+
+	before := fields["review-status"]
+	fields["review-status"] = reviewPending
+	fields["review-requested"] = time.Now().UTC().Format(svc.TimeFormat)
+	fields["review-advisors"] = strings.Join(advisors, ",")
+	delete(fields, "review-date")
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		log.Printf("ERROR: unable to submit work %s for review: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+	svc.auditReviewStatus(claims.auditActor(), workID, before, reviewPending)
+
+	for _, advisor := range advisors {
+		if err := svc.addReviewer(claims.auditActor(), workID, advisor); err != nil {
+			log.Printf("ERROR: unable to give advisor %s access to work %s: %s", advisor, workID, err.Error())
+			continue
+		}
+		if err := svc.sendNotification(claims.auditActor(), workID, reviewRequestNotice, fmt.Sprintf("%s@virginia.edu", advisor), svc.newEmailData(work)); err != nil {
+			log.Printf("ERROR: unable to send review request for work %s to %s: %s", workID, advisor, err.Error())
+		}
+	}
+	c.String(http.StatusOK, reviewPending)
+}
+
+// addReviewer gives an advisor view access to a work unless they are already a collaborator
+func (svc *serviceContext) addReviewer(actor auditActor, workID string, advisor string) error {
+	collab := workCollaborator{
+		WorkID:     workID,
+		ComputeID:  advisor,
+		Permission: collaboratorView,
+		AddedBy:    actor.computeID,
+		AddedAt:    time.Now(),
+	}
+	resp := svc.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&collab)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.RowsAffected > 0 {
+		svc.publishAuditEvent(actor, svc.Namespace, workID, auditCollaborator(actor.computeID, nil, &collab))
+	}
+	return nil
+}
+
+// reviewDecision records an advisor approval or request for changes on a work pending review
+func (svc *serviceContext) reviewDecision(c *gin.Context) {
+	claims := getJWTClaims(c)
+	var req reviewDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("ERROR: bad payload in review decision request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Decision != reviewApproved && req.Decision != reviewChanges {
+		c.String(http.StatusBadRequest, fmt.Sprintf("decision must be %s or %s", reviewApproved, reviewChanges))
+		return
+	}
+	req.Comments = strings.TrimSpace(req.Comments)
+	if req.Decision == reviewChanges && req.Comments == "" {
+		c.String(http.StatusBadRequest, "comments are required when requesting changes")
+		return
+	}
+
+	tgtObj, work := svc.getReviewWork(c)
+	if tgtObj == nil {
+		return
+	}
+	workID := tgtObj.Id()
+	log.Printf("INFO: %s records review decision %s for work %s", claims.ComputeID, req.Decision, workID)
+	if svc.isWorkEditor(claims.ComputeID, tgtObj) {
+		log.Printf("INFO: editor %s attempted to review work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "the depositor and editors of a work cannot review it")
+		return
+	}
+	// decisions are limited to the advisors the work was submitted to, not the advisors currently listed
+	isAdvisor := slices.Contains(reviewAdvisors(tgtObj), claims.ComputeID)
+	if (isAdvisor || claims.isAdmin()) == false {
+		log.Printf("INFO: unauthorized attempt by %s to review work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "only an advisor of this work can review it")
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, req.Version) == false {
+		return
+	}
+	fields := tgtObj.Fields()
+	if fields["review-status"] != reviewPending {
+		log.Printf("INFO: work %s is not pending review", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s is not pending review", workID))
+		return
+	}
+
+	rec := workReview{
+		WorkID:    workID,
+		ComputeID: claims.ComputeID,
+		Decision:  req.Decision,
+		Comments:  req.Comments,
+		CreatedAt: time.Now(),
+	}
+	if err := svc.DB.Create(&rec).Error; err != nil {
+		log.Printf("ERROR: unable to save review decision for work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	fields["review-status"] = req.Decision
+	fields["review-date"] = time.Now().UTC().Format(svc.TimeFormat)
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		log.Printf("ERROR: unable to update review status of work %s: %s", workID, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+	svc.auditReviewStatus(claims.auditActor(), workID, reviewPending, req.Decision)
+
+	notice := svc.newEmailData(work)
+	notice.Decision = req.Decision
+	notice.Comments = req.Comments
+	if err := svc.sendNotification(claims.auditActor(), workID, reviewDecisionNotice, depositorEmail(tgtObj), notice); err != nil {
+		log.Printf("ERROR: unable to send review decision for work %s: %s", workID, err.Error())
+	}
+	c.JSON(http.StatusOK, rec)
+}
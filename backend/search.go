@@ -107,7 +107,7 @@ func (svc *serviceContext) userSearch(c *gin.Context) {
 
 	log.Printf("INFO: find user %s works", computeID)
 	sort := []string{"metadata.title:asc"}
-	payload := map[string]any{"filter": fmt.Sprintf("fields.depositor=%s", computeID), "limit": 250, "sort": sort}
+	payload := map[string]any{"filter": fmt.Sprintf("fields.depositor=%s AND %s", computeID, notDeletedFilter), "limit": 250, "sort": sort}
 	url := fmt.Sprintf("%s/indexes/works/search", svc.IndexURL)
 	rawResp, respErr := svc.sendPostRequest(url, payload)
 	if respErr != nil {
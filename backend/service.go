@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +28,7 @@ type eventContext struct {
 	BusName     string
 	EventSource string
 	Bus         uvalibrabus.UvaBus
+	Notify      chan struct{}
 }
 
 // services that require jwt authorization and the necessary token
@@ -68,22 +71,31 @@ func (ps *protectedServices) refreshJWT(key string) error {
 
 // serviceContext contains common data used by all handlers
 type serviceContext struct {
-	Version         string
-	DB              *gorm.DB
-	EtdURL          string
-	TimeFormat      string
-	HTTPClient      *http.Client
-	EasyStore       uvaeasystore.EasyStore
-	Events          eventContext
-	AuditQueryURL   string
-	IndexURL        string
-	MetricsQueryURL string
-	Protected       protectedServices
-	JWTKey          string
-	Namespace       string
-	UVAWhiteList    []*net.IPNet
-	MimeTypes       []string
-	Dev             devConfig
+	Version            string
+	DB                 *gorm.DB
+	EtdURL             string
+	TimeFormat         string
+	HTTPClient         *http.Client
+	EasyStore          uvaeasystore.EasyStore
+	Events             eventContext
+	AuditQueryURL      string
+	IndexURL           string
+	MetricsQueryURL    string
+	Protected          protectedServices
+	JWTKey             string
+	Namespace          string
+	UVAWhiteList       []*net.IPNet
+	MimeTypes          []string
+	Uploads            uploadConfig
+	UploadSlots        chan struct{}
+	TrashRetentionDays int
+	DataCite           dataciteConfig
+	Notifier           notifier
+	EmailTemplates     *template.Template
+	EmailSender        string
+	EmbargoNoticeDays  int
+	Readiness          *readinessConfig
+	Dev                devConfig
 }
 
 // RequestError contains http status code and message for a failed HTTP request
@@ -130,6 +142,8 @@ type program struct {
 	Type    string `json:"type"` // optional or sis
 	SISKey  string `gorm:"column:sis_key" json:"sisKey,omitempty"`
 	Program string `json:"program"`
+	// works in programs that require review must be approved by an advisor before publication
+	ReviewRequired bool `json:"reviewRequired"`
 }
 
 type configResponse struct {
@@ -147,24 +161,35 @@ type configResponse struct {
 // max hits returned from the search index
 const targetMaxHits = 2500
 
+// index attributes used in search filters: fields.deleted excludes trashed works from every search,
+// fields.embargo-release is used by the embargo processor and metadata.program and metadata.degree by
+// OAI-PMH sets. keep in sync with package/scripts/index-settings.sh
+var indexFilterAttributes = []string{"fields.deleted", "fields.embargo-release", "metadata.program", "metadata.degree"}
+
 // InitializeService sets up the service context for all API handlers
 func initializeService(version string, cfg *configData) *serviceContext {
 	ctx := serviceContext{
-		Version:         version,
-		TimeFormat:      "2006-01-02T15:04:05Z",
-		Dev:             cfg.dev,
-		JWTKey:          cfg.jwtKey,
-		Namespace:       cfg.namespace,
-		EtdURL:          cfg.etdURL,
-		AuditQueryURL:   cfg.auditQueryURL,
-		IndexURL:        cfg.indexURL,
-		MetricsQueryURL: cfg.metricsQueryURL,
+		Version:            version,
+		TimeFormat:         "2006-01-02T15:04:05Z",
+		Dev:                cfg.dev,
+		Uploads:            cfg.uploads,
+		UploadSlots:        make(chan struct{}, max(cfg.uploads.maxTransfers, 1)),
+		TrashRetentionDays: cfg.trashRetention,
+		DataCite:           cfg.datacite,
+		EmailSender:        cfg.email.sender,
+		EmbargoNoticeDays:  cfg.email.embargoNotice,
+		JWTKey:             cfg.jwtKey,
+		Namespace:          cfg.namespace,
+		EtdURL:             cfg.etdURL,
+		AuditQueryURL:      cfg.auditQueryURL,
+		IndexURL:           cfg.indexURL,
+		MetricsQueryURL:    cfg.metricsQueryURL,
 	}
 
 	log.Printf("INFO: connecting to database")
 	connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d",
 		cfg.db.user, cfg.db.pass, cfg.db.name, cfg.db.host, cfg.db.port)
-	gdb, err := gorm.Open(postgres.Open(connStr), &gorm.Config{})
+	gdb, err := gorm.Open(postgres.Open(connStr), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -194,6 +219,13 @@ func initializeService(version string, cfg *configData) *serviceContext {
 		log.Fatalf("unable to load mime types: %s", err.Error())
 	}
 
+	log.Printf("INFO: load publication readiness rules")
+	readiness, err := loadReadinessConfig("./data/readiness.json")
+	if err != nil {
+		log.Fatalf("unable to load publication readiness rules: %s", err.Error())
+	}
+	ctx.Readiness = readiness
+
 	log.Printf("INFO: create HTTP client...")
 	defaultTransport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -232,6 +264,7 @@ func initializeService(version string, cfg *configData) *serviceContext {
 	log.Printf("INFO: easystore configured")
 
 	ctx.Events.DevMode = cfg.dev.fakeBus
+	ctx.Events.Notify = make(chan struct{}, 1)
 	ctx.Events.BusName = cfg.busName
 	ctx.Events.EventSource = cfg.eventSourceName
 	if cfg.dev.fakeBus == false {
@@ -247,6 +280,8 @@ func initializeService(version string, cfg *configData) *serviceContext {
 		ctx.Events.Bus = bus
 	}
 
+	ctx.Notifier, ctx.EmailTemplates = initNotifier(cfg)
+
 	if err := ctx.configSearchIndex(); err != nil {
 		log.Fatalf("unable to configure search index: %s", err.Error())
 	}
@@ -266,6 +301,7 @@ func (svc *serviceContext) configSearchIndex() error {
 		Pagination struct {
 			MaxTotalHits int `json:"maxTotalHits"`
 		} `json:"pagination"`
+		FilterableAttributes []string `json:"filterableAttributes"`
 	}
 	if err := json.Unmarshal(out, &cfgResp); err != nil {
 		return err
@@ -287,6 +323,16 @@ func (svc *serviceContext) configSearchIndex() error {
 	} else {
 		log.Printf("INFO: no config update needed")
 	}
+
+	// changing filterable attributes rebuilds the index, so it is done by package/scripts/index-settings.sh
+	// at deploy time. an attribute is also filterable when its parent object or everything is filterable
+	for _, attr := range indexFilterAttributes {
+		parent, _, _ := strings.Cut(attr, ".")
+		if slices.Contains(cfgResp.FilterableAttributes, attr) == false && slices.Contains(cfgResp.FilterableAttributes, parent) == false &&
+			slices.Contains(cfgResp.FilterableAttributes, "*") == false {
+			log.Printf("WARNING: %s is not a filterable index attribute; searches will fail until index-settings.sh is run", attr)
+		}
+	}
 	return nil
 }
 
@@ -301,13 +347,26 @@ func (svc *serviceContext) publishEvent(eventName, namespace, oid string) {
 		Namespace:  namespace,
 		Identifier: oid,
 	}
-	if svc.Events.DevMode {
-		log.Printf("INFO: dev mode send %+v to bus [%s] with source [%s]", ev, svc.Events.BusName, svc.Events.EventSource)
-	} else {
-		err := svc.Events.Bus.PublishEvent(&ev)
-		if err != nil {
-			log.Printf("ERROR: unable to publish event %s %s - %s: %s", eventName, namespace, oid, err.Error())
+	svc.queueEvent(&ev)
+}
+
+// runExclusive runs a background job only if no other service instance is running the job of the same name.
+// A transaction scoped advisory lock is held while the job runs; when another instance holds it the run is skipped.
+func (svc *serviceContext) runExclusive(jobName string, job func()) {
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("select pg_try_advisory_xact_lock(hashtext(?))", jobName).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if locked == false {
+			log.Printf("INFO: %s is running in another instance; skip this run", jobName)
+			return nil
 		}
+		job()
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to run %s: %s", jobName, err.Error())
 	}
 }
 
@@ -334,20 +393,16 @@ func (svc *serviceContext) getConfig(c *gin.Context) {
 	}
 
 	log.Printf("INFO: load programs")
-	if err := svc.DB.Raw("select id, type, sis_key, program from programs").Scan(&resp.Programs).Error; err != nil {
+	if err := svc.DB.Raw("select id, type, sis_key, program, review_required from programs").Scan(&resp.Programs).Error; err != nil {
 		log.Printf("ERROR: unable to load programs: %s", err.Error())
 	}
 
 	log.Printf("INFO: load languages")
-	bytes, err := os.ReadFile("./data/languages.json")
+	languages, err := loadLanguages()
 	if err != nil {
-		log.Printf("ERROR: unable to load languages: %s", err.Error())
-	} else {
-		err = json.Unmarshal(bytes, &resp.Languages)
-		if err != nil {
-			log.Printf("ERROR: unable to parse languages: %s", err.Error())
-		}
+		log.Printf("ERROR: %s", err.Error())
 	}
+	resp.Languages = languages
 
 	log.Printf("INFO: load licenses")
 	if err := svc.DB.Raw("select id,label,url from licenses").Scan(&resp.Licenses).Error; err != nil {
@@ -355,19 +410,39 @@ func (svc *serviceContext) getConfig(c *gin.Context) {
 	}
 
 	log.Printf("INFO: load visibility")
-	bytes, err = os.ReadFile("./data/visibility.json")
+	visibilities, err := loadVisibility()
 	if err != nil {
-		log.Printf("ERROR: unable to load visibility: %s", err.Error())
-	} else {
-		err = json.Unmarshal(bytes, &resp.Visibility)
-		if err != nil {
-			log.Printf("ERROR: unable to parse visibility: %s", err.Error())
-		}
+		log.Printf("ERROR: %s", err.Error())
 	}
+	resp.Visibility = visibilities
 
 	c.JSON(http.StatusOK, resp)
 }
 
+func loadLanguages() ([]language, error) {
+	var languages []language
+	bytes, err := os.ReadFile("./data/languages.json")
+	if err != nil {
+		return nil, fmt.Errorf("unable to load languages: %s", err.Error())
+	}
+	if err := json.Unmarshal(bytes, &languages); err != nil {
+		return nil, fmt.Errorf("unable to parse languages: %s", err.Error())
+	}
+	return languages, nil
+}
+
+func loadVisibility() ([]visibility, error) {
+	var visibilities []visibility
+	bytes, err := os.ReadFile("./data/visibility.json")
+	if err != nil {
+		return nil, fmt.Errorf("unable to load visibility: %s", err.Error())
+	}
+	if err := json.Unmarshal(bytes, &visibilities); err != nil {
+		return nil, fmt.Errorf("unable to parse visibility: %s", err.Error())
+	}
+	return visibilities, nil
+}
+
 func (svc *serviceContext) lookupVersion() map[string]string {
 	build := "unknown"
 	// working directory is the bin directory, and build tag is in the root
@@ -459,21 +534,32 @@ func (svc *serviceContext) lookupComputeID(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	user, userErr := svc.getUserDetails(computeID)
+	if userErr != nil {
+		if userErr.StatusCode == http.StatusNotFound {
+			log.Printf("INFO: lookup info user [%s] failed: %s", computeID, userErr.Message)
+		} else {
+			log.Printf("ERROR: lookup info user [%s] failed: %s", computeID, userErr.Message)
+		}
+		c.String(userErr.StatusCode, userErr.Message)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// getUserDetails looks up a compute ID in the user service. The protected services JWT must be current.
+func (svc *serviceContext) getUserDetails(computeID string) (*UserDetails, *RequestError) {
 	url := fmt.Sprintf("%s/user/%s?auth=%s", svc.Protected.UserServiceURL, computeID, svc.Protected.JWT)
 	resp, userErr := svc.sendGetRequest(url)
 	if userErr != nil {
-		log.Printf("INFO: lookup info user [%s] failed: %s", computeID, userErr.Message)
-		c.String(http.StatusNotFound, fmt.Sprintf("%s not found", computeID))
-		return
+		return nil, &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("%s not found", computeID)}
 	}
 
 	var jsonResp userServiceResp
 	if err := json.Unmarshal(resp, &jsonResp); err != nil {
-		log.Printf("ERROR: unable to parse user serice responce: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("unable to parse user service response: %s", err.Error())}
 	}
-	c.JSON(http.StatusOK, jsonResp.User)
+	return &jsonResp.User, nil
 }
 
 // OrcidDetailsResponse is the response from the Orcid service
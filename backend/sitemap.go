@@ -60,7 +60,7 @@ func generateSitemap(svc *serviceContext, baseURL string) (*urlSet, error) {
 
 	log.Printf("INFO: generate sitemap by requesting identifiers in batches of %d", limit)
 	for done == false {
-		payload := map[string]any{"filter": []string{"fields.draft=false"},
+		payload := map[string]any{"filter": []string{"fields.draft=false", notDeletedFilter},
 			"fields": []string{"id", "modified"},
 			"offset": offset,
 			"limit":  limit,
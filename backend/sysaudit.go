@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// system audit actions
+const (
+	sysAuditImpersonate = "impersonate"
+	sysAuditMimeTypes   = "mime-types"
+	sysAuditEventReplay = "event-replay"
+)
+
+// systemAudit is an audit of an action that is not a change to a single work. Target identifies what
+// the action was applied to, such as the impersonated user. System audits are only stored
+// locally; they are not sent to the bus as there is no work for them to be attached to.
+type systemAudit struct {
+	ID             uint64    `json:"-"`
+	Who            string    `json:"who"`
+	ImpersonatedBy string    `json:"impersonatedBy,omitempty"`
+	Action         string    `json:"action"`
+	Target         string    `json:"target"`
+	Before         string    `json:"before"`
+	After          string    `json:"after"`
+	EventTime      time.Time `json:"eventTime"`
+}
+
+type systemAuditResp struct {
+	Total  int64         `json:"total"`
+	Audits []systemAudit `json:"audits"`
+}
+
+func (svc *serviceContext) publishSystemAudit(actor auditActor, action string, target string, before, after string) {
+	rec := systemAudit{
+		Who:            actor.computeID,
+		ImpersonatedBy: actor.impersonatedBy,
+		Action:         action,
+		Target:         target,
+		Before:         before,
+		After:          after,
+		EventTime:      time.Now(),
+	}
+	if err := svc.DB.Create(&rec).Error; err != nil {
+		log.Printf("ERROR: unable to store %s system audit for %s by %s: %s", action, target, actor.computeID, err.Error())
+	}
+}
+
+// adminGetSystemAudits returns the system audit feed, newest first. Supported filters are action,
+// user, target and a from/to date range; offset and limit page through the results.
+func (svc *serviceContext) adminGetSystemAudits(c *gin.Context) {
+	claims := getJWTClaims(c)
+	log.Printf("INFO: admin %s requests system audits", claims.ComputeID)
+	query := svc.DB.Model(&systemAudit{})
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action=?", action)
+	}
+	if user := c.Query("user"); user != "" {
+		query = query.Where("(who=? or impersonated_by=?)", user, user)
+	}
+	if target := c.Query("target"); target != "" {
+		query = query.Where("target=?", target)
+	}
+	for _, param := range []string{"from", "to"} {
+		dateStr := c.Query(param)
+		if dateStr == "" {
+			continue
+		}
+		filterDate, dateOnly, err := parseAuditDate(dateStr)
+		if err != nil {
+			log.Printf("INFO: invalid system audit %s date %s: %s", param, dateStr, err.Error())
+			c.String(http.StatusBadRequest, fmt.Sprintf("invalid %s date %s", param, dateStr))
+			return
+		}
+		if param == "from" {
+			query = query.Where("event_time >= ?", filterDate)
+		} else {
+			// a date with no time includes the full day
+			if dateOnly {
+				filterDate = filterDate.Add(24 * time.Hour)
+			}
+			query = query.Where("event_time < ?", filterDate)
+		}
+	}
+
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	resp := systemAuditResp{Audits: make([]systemAudit, 0)}
+	if err := query.Count(&resp.Total).Error; err != nil {
+		log.Printf("ERROR: unable to count system audits: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := query.Order("event_time desc, id desc").Offset(offset).Limit(limit).Find(&resp.Audits).Error; err != nil {
+		log.Printf("ERROR: unable to get system audits: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+)
+
+// events published when a work moves into or out of the trash, or is permanently removed
+const (
+	eventWorkDelete  = "work.delete"
+	eventWorkRestore = "work.restore"
+	eventWorkPurge   = "work.purge"
+)
+
+// search index filter that excludes works in the trash
+const notDeletedFilter = "fields.deleted NOT EXISTS"
+
+// trashedWork records a deleted work until it is restored or purged. Title and author are kept
+// so the trash can be listed without loading each work from easystore.
+type trashedWork struct {
+	ID         uint64    `json:"-"`
+	WorkID     string    `json:"workID"`
+	Namespace  string    `json:"namespace"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author"`
+	Depositor  string    `json:"depositor"`
+	DeletedBy  string    `json:"deletedBy"`
+	DeletedAt  time.Time `json:"deletedAt"`
+	PurgeAfter time.Time `json:"purgeAfter"`
+}
+
+func isTrashed(tgtObj uvaeasystore.EasyStoreObject) bool {
+	return tgtObj.Fields()["deleted"] != ""
+}
+
+func (svc *serviceContext) auditTrashChange(actor auditActor, workID string, fieldName, before, after string) {
+	auditEvt := uvalibrabus.UvaAuditEvent{
+		Who:       actor.computeID,
+		FieldName: fieldName,
+		Before:    before,
+		After:     after,
+	}
+	svc.publishAuditEvent(actor, svc.Namespace, workID, auditEvt)
+}
+
+// moveToTrash flags a work as deleted, records it in the trash and publishes the delete event
+func (svc *serviceContext) moveToTrash(actor auditActor, delObj uvaeasystore.EasyStoreObject) error {
+	workID := delObj.Id()
+	now := time.Now()
+	trashRec := trashedWork{
+		WorkID:     workID,
+		Namespace:  svc.Namespace,
+		Depositor:  delObj.Fields()["depositor"],
+		DeletedBy:  actor.computeID,
+		DeletedAt:  now,
+		PurgeAfter: now.AddDate(0, 0, svc.TrashRetentionDays),
+	}
+	if delWork, err := svc.parseWork(delObj, false); err != nil {
+		log.Printf("WARNING: unable to parse work %s for trash details: %s", workID, err.Error())
+	} else {
+		trashRec.Title = delWork.Title
+		trashRec.Author = fmt.Sprintf("%s, %s", delWork.Author.LastName, delWork.Author.FirstName)
+	}
+
+	// the trash record is created first; a deleted work without one could never be listed, restored or purged
+	log.Printf("INFO: move %s work %s to the trash", svc.Namespace, workID)
+	if err := svc.DB.Create(&trashRec).Error; err != nil {
+		return fmt.Errorf("unable to create trash record for work %s: %w", workID, err)
+	}
+	fields := delObj.Fields()
+	fields["deleted"] = now.UTC().Format(svc.TimeFormat)
+	fields["deleted-by"] = actor.computeID
+	delObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(delObj, uvaeasystore.Fields); err != nil {
+		if dbErr := svc.DB.Delete(&trashRec).Error; dbErr != nil {
+			log.Printf("ERROR: unable to remove trash record for work %s that was not deleted: %s", workID, dbErr.Error())
+		}
+		return err
+	}
+	svc.auditTrashChange(actor, workID, "deleted", "", fields["deleted"])
+	svc.publishEvent(eventWorkDelete, svc.Namespace, workID)
+	if err := svc.cancelWorkRegistration(actor, workID); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	return nil
+}
+
+func (svc *serviceContext) adminGetTrash(c *gin.Context) {
+	log.Printf("INFO: get trashed works")
+	var trash []trashedWork
+	if err := svc.DB.Where("namespace=?", svc.Namespace).Order("deleted_at desc").Find(&trash).Error; err != nil {
+		log.Printf("ERROR: unable to get trashed works: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, trash)
+}
+
+func (svc *serviceContext) adminRestoreWork(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	log.Printf("INFO: admin %s requests restore of work %s from the trash", claims.ComputeID, workID)
+
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for restore: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if isTrashed(tgtObj) == false {
+		log.Printf("INFO: work %s is not in the trash", workID)
+		c.String(http.StatusConflict, fmt.Sprintf("%s is not in the trash", workID))
+		return
+	}
+
+	fields := tgtObj.Fields()
+	deletedAt := fields["deleted"]
+	delete(fields, "deleted")
+	delete(fields, "deleted-by")
+	fields["modify-date"] = time.Now().UTC().Format(svc.TimeFormat)
+	tgtObj.SetFields(fields)
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields); err != nil {
+		log.Printf("ERROR: unable to restore work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := svc.DB.Where("work_id=?", workID).Delete(&trashedWork{}).Error; err != nil {
+		log.Printf("ERROR: unable to remove trash record for restored work %s: %s", workID, err.Error())
+	}
+	svc.auditTrashChange(claims.auditActor(), workID, "deleted", deletedAt, "")
+	svc.publishEvent(eventWorkRestore, svc.Namespace, workID)
+	if fields["draft"] == "true" {
+		if err := svc.reopenWorkRegistration(claims.auditActor(), workID); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+		}
+	}
+
+	// a restored published work is public again; a restored draft goes back to being unpublished
+	if fields["draft"] == "false" {
+		svc.clearWithdrawal(workID)
+	} else if err := svc.DB.Model(&withdrawal{}).Where("work_id=?", workID).Update("withdrawn_type", withdrawnUnpublished).Error; err != nil {
+		log.Printf("ERROR: unable to update withdrawal record for restored work %s: %s", workID, err.Error())
+	}
+
+	// the DOI was hidden when the work was deleted; make it findable again for published works
+	if fields["draft"] == "false" && fields["doi"] != "" {
+		if _, err := svc.registerDOI(tgtObj); err != nil {
+			log.Printf("ERROR: unable to register doi for restored work %s: %s", workID, err.Error())
+		}
+	}
+
+	c.String(http.StatusOK, "restored")
+}
+
+// startTrashPurge periodically permanently deletes works that have been in the trash longer than the retention period
+func (svc *serviceContext) startTrashPurge() {
+	log.Printf("INFO: start trash purge; works are removed %d days after deletion", svc.TrashRetentionDays)
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for {
+			svc.runExclusive("trash-purge", svc.purgeTrash)
+			<-ticker.C
+		}
+	}()
+}
+
+func (svc *serviceContext) purgeTrash() {
+	var expired []trashedWork
+	if err := svc.DB.Where("namespace=? and purge_after < ?", svc.Namespace, time.Now()).Find(&expired).Error; err != nil {
+		log.Printf("ERROR: unable to find expired trash: %s", err.Error())
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	log.Printf("INFO: purge %d works from the trash", len(expired))
+	for _, rec := range expired {
+		if err := svc.purgeWork(&rec); err != nil {
+			log.Printf("ERROR: unable to purge work %s: %s", rec.WorkID, err.Error())
+		}
+	}
+}
+
+func (svc *serviceContext) purgeWork(rec *trashedWork) error {
+	delObj, err := svc.EasyStore.ObjectGetByKey(rec.Namespace, rec.WorkID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	if err != nil {
+		if strings.Contains(err.Error(), "not exist") == false {
+			return err
+		}
+		log.Printf("WARNING: trashed work %s no longer exists; remove trash record", rec.WorkID)
+	} else {
+		// a restore may have raced with this purge; never delete a work that is no longer in the trash
+		if isTrashed(delObj) == false {
+			log.Printf("WARNING: work %s has a trash record but is not deleted; skip purge", rec.WorkID)
+			return svc.DB.Delete(rec).Error
+		}
+		log.Printf("INFO: permanently delete %s work %s", rec.Namespace, rec.WorkID)
+		if _, err := svc.EasyStore.ObjectDelete(delObj, uvaeasystore.AllComponents); err != nil {
+			return err
+		}
+	}
+
+	if err := svc.DB.Delete(rec).Error; err != nil {
+		log.Printf("ERROR: unable to remove trash record for purged work %s: %s", rec.WorkID, err.Error())
+	}
+	if err := svc.DB.Where("work_id=?", rec.WorkID).Delete(&workCollaborator{}).Error; err != nil {
+		log.Printf("ERROR: unable to remove collaborators for purged work %s: %s", rec.WorkID, err.Error())
+	}
+	if err := svc.DB.Where("work_id=?", rec.WorkID).Delete(&workVersion{}).Error; err != nil {
+		log.Printf("ERROR: unable to remove version history for purged work %s: %s", rec.WorkID, err.Error())
+	}
+	if err := svc.cancelWorkRegistration(systemActor, rec.WorkID); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	svc.auditTrashChange(systemActor, rec.WorkID, "purged", "", time.Now().UTC().Format(svc.TimeFormat))
+	svc.publishEvent(eventWorkPurge, rec.Namespace, rec.WorkID)
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+)
+
+// allowance for multipart boundaries and headers on top of the max file size
+const multipartOverhead = 1024 * 1024
+
+// stagedUpload is an uploaded file that has been streamed to a temporary file on disk
+type stagedUpload struct {
+	fileName string
+	mimeType string
+	size     int64
+	path     string
+}
+
+func (svc *serviceContext) maxFileBytes() int64 {
+	return svc.Uploads.maxFileMB * 1024 * 1024
+}
+
+func (svc *serviceContext) maxWorkBytes() int64 {
+	return svc.Uploads.maxWorkMB * 1024 * 1024
+}
+
+// stageUpload streams the file part of a multipart upload request to a temporary file, enforcing the
+// per-file size limit as it goes. The caller must call remove on the result when done with it.
+func (svc *serviceContext) stageUpload(c *gin.Context) (*stagedUpload, *RequestError) {
+	maxBytes := svc.maxFileBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+	mpReader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("unable to get upload: %s", err.Error())}
+	}
+
+	for {
+		part, err := mpReader.NextPart()
+		if err == io.EOF {
+			return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: "upload does not contain a file"}
+		}
+		if err != nil {
+			return nil, uploadReadError(err, maxBytes)
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		// only 1 file can be uploaded at a time
+		upload, reqErr := svc.stagePart(part, part.FileName())
+		part.Close()
+		return upload, reqErr
+	}
+}
+
+// stagePart copies a single upload stream to a temporary file and sniffs the mime type from the first 512 bytes
+func (svc *serviceContext) stagePart(src io.Reader, fileName string) (*stagedUpload, *RequestError) {
+	maxBytes := svc.maxFileBytes()
+	tmpFile, err := os.CreateTemp(svc.Uploads.stagingDir, "libra-upload-*")
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("unable to stage %s: %s", fileName, err.Error())}
+	}
+	defer tmpFile.Close()
+
+	upload := stagedUpload{fileName: fileName, path: tmpFile.Name()}
+	log.Printf("INFO: stage upload %s to %s", fileName, upload.path)
+	upload.size, err = io.Copy(tmpFile, io.LimitReader(src, maxBytes+1))
+	if err != nil {
+		upload.remove()
+		return nil, uploadReadError(err, maxBytes)
+	}
+	if upload.size > maxBytes {
+		upload.remove()
+		return nil, fileTooLargeError(fileName, maxBytes)
+	}
+
+	if err := upload.sniffMimeType(); err != nil {
+		upload.remove()
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("unable to read %s: %s", fileName, err.Error())}
+	}
+	log.Printf("INFO: staged upload %s with size %d and mime type %s", fileName, upload.size, upload.mimeType)
+	return &upload, nil
+}
+
+// sniffMimeType detects the mime type of the staged file using only its first 512 bytes
+func (upload *stagedUpload) sniffMimeType() error {
+	stagedFile, err := os.Open(upload.path)
+	if err != nil {
+		return err
+	}
+	defer stagedFile.Close()
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(stagedFile, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	upload.mimeType = http.DetectContentType(sniff[:n])
+	return nil
+}
+
+// checkMimeType validates the staged upload against the configured list of supported mime types. The sniffed
+// content type is used when it is specific; when sniffing only yields a generic type, the type implied by the
+// file extension must be supported instead. Admins can bypass the check with an override=true query param.
+func (svc *serviceContext) checkMimeType(c *gin.Context, upload *stagedUpload) *RequestError {
+	if c.Query("override") == "true" {
+		claims := getJWTClaims(c)
+		if claims != nil && claims.isAdmin() {
+			log.Printf("INFO: admin %s overrides mime type check for %s (%s)", claims.ComputeID, upload.fileName, upload.mimeType)
+			return nil
+		}
+		log.Printf("WARNING: ignoring mime type override from non-admin user")
+	}
+
+	sniffedType := baseMimeType(upload.mimeType)
+	extType := baseMimeType(mime.TypeByExtension(strings.ToLower(filepath.Ext(upload.fileName))))
+	log.Printf("INFO: check %s with sniffed type [%s] and extension type [%s] against supported types", upload.fileName, sniffedType, extType)
+
+	genericTypes := []string{"application/octet-stream", "text/plain", "application/zip"}
+	if svc.isSupportedMimeType(sniffedType) {
+		if slices.Contains(genericTypes, sniffedType) == false || extType == "" || svc.isSupportedMimeType(extType) {
+			return nil
+		}
+	} else if slices.Contains(genericTypes, sniffedType) && extType != "" && svc.isSupportedMimeType(extType) {
+		return nil
+	}
+
+	detected := sniffedType
+	if slices.Contains(genericTypes, sniffedType) && extType != "" {
+		detected = extType
+	}
+	return &RequestError{StatusCode: http.StatusUnsupportedMediaType,
+		Message: fmt.Sprintf("%s has unsupported type %s; supported types are: %s", upload.fileName, detected, strings.Join(svc.MimeTypes, ", "))}
+}
+
+// isSupportedMimeType checks a mime type against the supported list, including wildcard entries such as image/*
+func (svc *serviceContext) isSupportedMimeType(mimeType string) bool {
+	if mimeType == "" {
+		return false
+	}
+	for _, supported := range svc.MimeTypes {
+		supported = strings.ToLower(strings.TrimSpace(supported))
+		if supported == mimeType {
+			return true
+		}
+		if strings.HasSuffix(supported, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(supported, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseMimeType strips any parameters, like charset, from a mime type
+func baseMimeType(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+// storeUpload creates an easystore blob from the staged file and passes it to store. This is NOT streamed:
+// the easystore blob API takes the full payload as a byte slice, so the whole file is read into memory.
+// The file is only read once all size and type checks have passed, and at most maxtransfers uploads are
+// held in memory at once, so peak upload memory is maxtransfers * maxfilesize. Files that do not fit in
+// memory cannot be stored; see the Upload Notes in the README.
+func (svc *serviceContext) storeUpload(upload *stagedUpload, name string, store func(esBlob uvaeasystore.EasyStoreBlob) error) error {
+	svc.UploadSlots <- struct{}{}
+	defer func() { <-svc.UploadSlots }()
+
+	payload, err := os.ReadFile(upload.path)
+	if err != nil {
+		return fmt.Errorf("unable to read staged upload %s: %s", upload.fileName, err.Error())
+	}
+	return store(uvaeasystore.NewEasyStoreBlob(name, upload.mimeType, payload))
+}
+
+func (upload *stagedUpload) remove() {
+	if err := os.Remove(upload.path); err != nil && errors.Is(err, os.ErrNotExist) == false {
+		log.Printf("WARNING: unable to remove staged upload %s: %s", upload.path, err.Error())
+	}
+}
+
+// checkWorkSize ensures that adding a file of addSize bytes (optionally replacing the file named replaceName)
+// will not push the total size of all files in the work over the configured limit
+func (svc *serviceContext) checkWorkSize(tgtObj uvaeasystore.EasyStoreObject, addSize int64, replaceName string) *RequestError {
+	total := addSize
+	for _, esBlob := range tgtObj.Files() {
+		if esBlob.Name() == replaceName {
+			continue
+		}
+		size, err := svc.getFileSize(esBlob.Url())
+		if err != nil {
+			log.Printf("WARNING: unable to determine size of %s in work %s: %s", esBlob.Name(), tgtObj.Id(), err.Error())
+			continue
+		}
+		total += size
+	}
+	log.Printf("INFO: work %s total file size will be %d bytes", tgtObj.Id(), total)
+	if total > svc.maxWorkBytes() {
+		return &RequestError{StatusCode: http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("the total size of all files in a work cannot exceed %d MB", svc.Uploads.maxWorkMB)}
+	}
+	return nil
+}
+
+// getFileSize requests the first byte of a stored file and reads the full size from the Content-Range header
+func (svc *serviceContext) getFileSize(url string) (int64, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := svc.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// format: bytes 0-0/12345
+		bits := strings.Split(resp.Header.Get("Content-Range"), "/")
+		return strconv.ParseInt(bits[len(bits)-1], 10, 64)
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	}
+	return 0, fmt.Errorf("size request failed with status %d", resp.StatusCode)
+}
+
+func uploadReadError(err error, maxBytes int64) *RequestError {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return fileTooLargeError("upload", maxBytes)
+	}
+	return &RequestError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("unable to read upload: %s", err.Error())}
+}
+
+func fileTooLargeError(fileName string, maxBytes int64) *RequestError {
+	return &RequestError{StatusCode: http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("%s exceeds the maximum file size of %d MB", fileName, maxBytes/(1024*1024))}
+}
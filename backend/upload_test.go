@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadContext builds a test context holding a multipart request with a single file part
+func uploadContext(t *testing.T, fileName string, content []byte) *gin.Context {
+	t.Helper()
+	var body bytes.Buffer
+	mpWriter := multipart.NewWriter(&body)
+	part, err := mpWriter.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatalf("unable to create file part: %s", err.Error())
+	}
+	part.Write(content)
+	mpWriter.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/works/1/files", &body)
+	c.Request.Header.Set("Content-Type", mpWriter.FormDataContentType())
+	return c
+}
+
+func TestStageUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{Uploads: uploadConfig{maxFileMB: 1, stagingDir: t.TempDir()}}
+	content := append([]byte("%PDF-1.5\n"), bytes.Repeat([]byte("x"), 4096)...)
+
+	upload, reqErr := svc.stageUpload(uploadContext(t, "thesis.pdf", content))
+	if reqErr != nil {
+		t.Fatalf("stageUpload failed: %d %s", reqErr.StatusCode, reqErr.Message)
+	}
+	defer upload.remove()
+
+	if upload.fileName != "thesis.pdf" || upload.mimeType != "application/pdf" || upload.size != int64(len(content)) {
+		t.Errorf("staged %s as %s with size %d; want thesis.pdf as application/pdf with size %d",
+			upload.fileName, upload.mimeType, upload.size, len(content))
+	}
+	staged, err := os.ReadFile(upload.path)
+	if err != nil {
+		t.Fatalf("unable to read staged file: %s", err.Error())
+	}
+	if bytes.Equal(staged, content) == false {
+		t.Errorf("staged file does not match the uploaded content")
+	}
+}
+
+func TestStageUploadTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stagingDir := t.TempDir()
+	svc := serviceContext{Uploads: uploadConfig{maxFileMB: 1, stagingDir: stagingDir}}
+	content := bytes.Repeat([]byte("x"), 1024*1024+1)
+
+	upload, reqErr := svc.stageUpload(uploadContext(t, "video.mp4", content))
+	if reqErr == nil {
+		upload.remove()
+		t.Fatalf("stageUpload accepted a file over the limit")
+	}
+	if reqErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("stageUpload status = %d; want %d", reqErr.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if left, _ := os.ReadDir(stagingDir); len(left) > 0 {
+		t.Errorf("rejected upload left %d staged files behind", len(left))
+	}
+}
+
+func TestStageUploadWithoutFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{Uploads: uploadConfig{maxFileMB: 1, stagingDir: t.TempDir()}}
+	var body bytes.Buffer
+	mpWriter := multipart.NewWriter(&body)
+	mpWriter.WriteField("note", "no file here")
+	mpWriter.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/works/1/files", &body)
+	c.Request.Header.Set("Content-Type", mpWriter.FormDataContentType())
+	if _, reqErr := svc.stageUpload(c); reqErr == nil || reqErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("stageUpload without a file = %v; want a 400", reqErr)
+	}
+}
+
+func TestCheckMimeType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{MimeTypes: []string{"application/pdf", "image/*", "text/plain"}}
+	admin := &jwtClaims{UserDetails: &UserDetails{ComputeID: "admin1", Role: "admin"}}
+	user := &jwtClaims{UserDetails: &UserDetails{ComputeID: "user1", Role: "user"}}
+
+	tests := []struct {
+		name     string
+		fileName string
+		sniffed  string
+		override bool
+		claims   *jwtClaims
+		wantOK   bool
+	}{
+		{name: "supported sniffed type", fileName: "thesis.pdf", sniffed: "application/pdf", wantOK: true},
+		{name: "wildcard type", fileName: "figure.png", sniffed: "image/png", wantOK: true},
+		{name: "generic sniff with supported extension", fileName: "thesis.pdf", sniffed: "application/octet-stream", wantOK: true},
+		{name: "generic sniff with no extension", fileName: "thesis", sniffed: "application/octet-stream"},
+		{name: "supported generic sniff with charset", fileName: "notes.txt", sniffed: "text/plain; charset=utf-8", wantOK: true},
+		{name: "supported generic sniff with unsupported extension", fileName: "page.html", sniffed: "text/plain; charset=utf-8"},
+		{name: "unsupported sniffed type", fileName: "page.html", sniffed: "text/html; charset=utf-8"},
+		{name: "admin override", fileName: "page.html", sniffed: "text/html; charset=utf-8", override: true, claims: admin, wantOK: true},
+		{name: "non-admin override ignored", fileName: "page.html", sniffed: "text/html; charset=utf-8", override: true, claims: user},
+		{name: "anonymous override ignored", fileName: "page.html", sniffed: "text/html; charset=utf-8", override: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			url := "/api/works/1/files"
+			if tc.override {
+				url += "?override=true"
+			}
+			c.Request = httptest.NewRequest(http.MethodPost, url, nil)
+			if tc.claims != nil {
+				c.Set("claims", *tc.claims)
+			}
+			upload := stagedUpload{fileName: tc.fileName, mimeType: tc.sniffed}
+			reqErr := svc.checkMimeType(c, &upload)
+			if tc.wantOK && reqErr != nil {
+				t.Errorf("checkMimeType(%s, %s) rejected: %s", tc.fileName, tc.sniffed, reqErr.Message)
+			}
+			if tc.wantOK == false {
+				if reqErr == nil {
+					t.Errorf("checkMimeType(%s, %s) accepted; want rejection", tc.fileName, tc.sniffed)
+				} else if reqErr.StatusCode != http.StatusUnsupportedMediaType {
+					t.Errorf("checkMimeType(%s, %s) status = %d; want %d", tc.fileName, tc.sniffed, reqErr.StatusCode, http.StatusUnsupportedMediaType)
+				}
+			}
+		})
+	}
+}
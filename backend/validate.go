@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/uvalib/easystore/uvaeasystore"
+)
+
+// visibilityRank orders the visibility values from most to least restricted. A work may only be
+// released to a visibility that is more open than its current one.
+var visibilityRank = map[string]int{"embargo": 0, "uva": 1, "open": 2}
+
+// validateWorkUpdate checks every field of a work update request and returns a map of request field
+// name to error message; an empty map means the update is valid. Values that are unchanged from the
+// current work are accepted as-is so works imported with legacy values can still be edited. The
+// license URL in the request is replaced with the URL of the selected license.
+func (svc *serviceContext) validateWorkUpdate(claims *jwtClaims, origObj uvaeasystore.EasyStoreObject, req *etdUpdateRequest) (map[string]string, error) {
+	errs := make(map[string]string)
+	origWork, err := svc.parseWork(origObj, false)
+	if err != nil {
+		return nil, err
+	}
+	origFields := origObj.Fields()
+
+	visibilities, err := loadVisibility()
+	if err != nil {
+		return nil, err
+	}
+	var tgtVis *visibility
+	for idx := range visibilities {
+		if visibilities[idx].Value == req.Visibility {
+			tgtVis = &visibilities[idx]
+		}
+	}
+	if req.Visibility == "" {
+		errs["visibility"] = "visibility is required"
+	} else if tgtVis == nil {
+		errs["visibility"] = fmt.Sprintf("%s is not a valid visibility", req.Visibility)
+	} else if tgtVis.AdminOnly && claims.isAdmin() == false && req.Visibility != origFields["default-visibility"] {
+		errs["visibility"] = fmt.Sprintf("%s visibility can only be set by an administrator", tgtVis.Label)
+	}
+
+	// an embargo is set by an administrator, and so are its release date and the visibility after release
+	if req.Visibility == "embargo" && claims.isAdmin() == false && svc.embargoReleaseChanged(origFields, req) {
+		errs["embargoReleaseDate"] = "the embargo end date and release visibility can only be changed by an administrator"
+	}
+
+	// release settings only apply to limited visibility; they are discarded for open works
+	if (req.Visibility == "uva" || req.Visibility == "embargo") && req.EmbargoReleaseDate != "" {
+		if endDate, err := time.Parse(svc.TimeFormat, req.EmbargoReleaseDate); err != nil {
+			errs["embargoReleaseDate"] = fmt.Sprintf("%s is not a valid date", req.EmbargoReleaseDate)
+		} else if req.Visibility == "uva" && claims.isAdmin() == false {
+			// for non-admin users, visibility must be public within 5 years per provost
+			maxYears := 5
+			if req.Work.Program == "Creative Writing" && req.Work.Degree == "MFA (Master of Fine Arts)" {
+				maxYears = 10
+			}
+			maxDate := time.Now().UTC().AddDate(maxYears, 0, 0).Truncate(24 * time.Hour)
+			if endDate.UTC().Truncate(24 * time.Hour).After(maxDate) {
+				errs["embargoReleaseDate"] = fmt.Sprintf("limited visibility end date must be less than %d years from today", maxYears)
+			}
+		}
+		releaseRank, valid := visibilityRank[req.EmbargoReleaseVisibility]
+		if req.EmbargoReleaseVisibility == "" {
+			errs["embargoReleaseVisibility"] = "the visibility after the end date is required"
+		} else if valid == false {
+			errs["embargoReleaseVisibility"] = fmt.Sprintf("%s is not a valid visibility", req.EmbargoReleaseVisibility)
+		} else if releaseRank <= visibilityRank[req.Visibility] {
+			errs["embargoReleaseVisibility"] = fmt.Sprintf("the visibility after the end date must be less restricted than %s", req.Visibility)
+		}
+	}
+
+	work := &req.Work
+	if work.License != "" && work.License != origWork.License {
+		var licenses []license
+		if err := svc.DB.Raw("select id,label,url from licenses").Scan(&licenses).Error; err != nil {
+			return nil, err
+		}
+		idx := slices.IndexFunc(licenses, func(l license) bool { return l.Label == work.License })
+		if idx < 0 {
+			errs["work.license"] = fmt.Sprintf("%s is not a valid license", work.License)
+		} else {
+			work.LicenseURL = licenses[idx].URL
+		}
+	}
+
+	if work.Language != "" && work.Language != origWork.Language {
+		languages, err := loadLanguages()
+		if err != nil {
+			return nil, err
+		}
+		if slices.IndexFunc(languages, func(l language) bool { return l.Value == work.Language }) < 0 {
+			errs["work.language"] = fmt.Sprintf("%s is not a valid language", work.Language)
+		}
+	}
+
+	// program and degree come from registration and decide whether advisor review is required, so
+	// only admins can change them
+	if claims.isAdmin() == false {
+		if work.Degree != origWork.Degree {
+			errs["work.degree"] = "the degree can only be changed by an administrator"
+		}
+		if work.Program != origWork.Program {
+			errs["work.program"] = "the program can only be changed by an administrator"
+		}
+	} else if work.Degree != "" && work.Degree != origWork.Degree {
+		var cnt int64
+		if err := svc.DB.Table("degrees").Where("degree=?", work.Degree).Count(&cnt).Error; err != nil {
+			return nil, err
+		}
+		if cnt == 0 {
+			errs["work.degree"] = fmt.Sprintf("%s is not a valid degree", work.Degree)
+		}
+	}
+	if claims.isAdmin() && work.Program != "" && work.Program != origWork.Program {
+		var cnt int64
+		if err := svc.DB.Table("programs").Where("program=?", work.Program).Count(&cnt).Error; err != nil {
+			return nil, err
+		}
+		if cnt == 0 {
+			errs["work.program"] = fmt.Sprintf("%s is not a valid program", work.Program)
+		}
+	}
+
+	if claims.isAdmin() == false && work.Author.ComputeID != origWork.Author.ComputeID {
+		errs["work.author.computeID"] = "the author computing id can only be changed by an administrator"
+	}
+	for idx, relURL := range work.RelatedURLs {
+		if strings.TrimSpace(relURL) == "" {
+			continue
+		}
+		parsed, err := url.Parse(strings.TrimSpace(relURL))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs[fmt.Sprintf("work.relatedURLs[%d]", idx)] = fmt.Sprintf("%s is not a valid web address", relURL)
+		}
+	}
+	return errs, nil
+}
+
+// embargoReleaseChanged returns true if the release date or release visibility in the request differs from the
+// current settings of the work. The release visibility only applies when there is a release date.
+func (svc *serviceContext) embargoReleaseChanged(origFields uvaeasystore.EasyStoreObjectFields, req *etdUpdateRequest) bool {
+	origRelease := origFields["embargo-release"]
+	if origRelease == "" || req.EmbargoReleaseDate == "" {
+		return origRelease != req.EmbargoReleaseDate
+	}
+	origDate, origErr := time.Parse(svc.TimeFormat, origRelease)
+	reqDate, reqErr := time.Parse(svc.TimeFormat, req.EmbargoReleaseDate)
+	if origErr != nil || reqErr != nil || origDate.Equal(reqDate) == false {
+		return true
+	}
+	return origFields["embargo-release-visibility"] != req.EmbargoReleaseVisibility
+}
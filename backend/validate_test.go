@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/uvalib/easystore/uvaeasystore"
+)
+
+func TestEmbargoReleaseChanged(t *testing.T) {
+	svc := serviceContext{TimeFormat: "2006-01-02T15:04:05Z"}
+	embargoed := uvaeasystore.EasyStoreObjectFields{"embargo-release": "2030-01-01T00:00:00Z", "embargo-release-visibility": "open"}
+	forever := uvaeasystore.EasyStoreObjectFields{"embargo-release": ""}
+
+	tests := []struct {
+		name       string
+		orig       uvaeasystore.EasyStoreObjectFields
+		date       string
+		visibility string
+		want       bool
+	}{
+		{name: "unchanged", orig: embargoed, date: "2030-01-01T00:00:00Z", visibility: "open"},
+		{name: "new release date", orig: embargoed, date: "2031-01-01T00:00:00Z", visibility: "open", want: true},
+		{name: "release date cleared", orig: embargoed, visibility: "open", want: true},
+		{name: "new release visibility", orig: embargoed, date: "2030-01-01T00:00:00Z", visibility: "uva", want: true},
+		{name: "invalid release date", orig: embargoed, date: "2030-01-01", visibility: "open", want: true},
+		{name: "forever embargo unchanged", orig: forever, visibility: "open"},
+		{name: "forever embargo given a release date", orig: forever, date: "2030-01-01T00:00:00Z", visibility: "open", want: true},
+	}
+	for _, tc := range tests {
+		req := etdUpdateRequest{}
+		req.EmbargoReleaseDate = tc.date
+		req.EmbargoReleaseVisibility = tc.visibility
+		if got := svc.embargoReleaseChanged(tc.orig, &req); got != tc.want {
+			t.Errorf("%s: embargoReleaseChanged = %t; want %t", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/easystore/uvaeasystore"
+	librametadata "github.com/uvalib/libra-metadata"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm"
+)
+
+// workVersion is a snapshot of the metadata and fields of a work, recorded after every update. The
+// first update of a work also records a baseline snapshot of the work as it was before the update.
+type workVersion struct {
+	ID        uint64    `json:"-"`
+	WorkID    string    `json:"-"`
+	Version   int       `json:"version"`
+	VTag      string    `gorm:"column:vtag" json:"vtag"`
+	Metadata  string    `json:"-"`
+	Fields    string    `json:"-"`
+	Note      string    `json:"note,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type versionChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+type versionDiff struct {
+	From    int             `json:"from"`
+	To      int             `json:"to"`
+	Changes []versionChange `json:"changes"`
+}
+
+// restorableFields are the easystore fields managed by updateWork; a restore only reverts these. Fields
+// such as draft, doi and publish-date are controlled by other workflows and are left untouched.
+var restorableFields = []string{"default-visibility", "embargo-release", "embargo-release-visibility"}
+
+// fields that change on every update and are left out of version diffs
+var unversionedFields = []string{"modify-date"}
+
+// recordVersion saves a snapshot of the current metadata and fields of a work as its next version
+func (svc *serviceContext) recordVersion(computeID string, tgtObj uvaeasystore.EasyStoreObject, note string, createdAt time.Time) (*workVersion, error) {
+	payload, err := tgtObj.Metadata().Payload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read metadata: %s", err.Error())
+	}
+	fields, err := json.Marshal(tgtObj.Fields())
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize fields: %s", err.Error())
+	}
+
+	rec := workVersion{
+		WorkID:    tgtObj.Id(),
+		VTag:      tgtObj.VTag(),
+		Metadata:  string(payload),
+		Fields:    string(fields),
+		Note:      note,
+		CreatedBy: computeID,
+		CreatedAt: createdAt,
+	}
+	// concurrent saves of a work would pick the same next version; a transaction scoped advisory lock
+	// on the work serializes the version number lookup and insert
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("select pg_advisory_xact_lock(hashtext(?))", rec.WorkID).Error; err != nil {
+			return err
+		}
+		var latest int
+		if err := tx.Raw("select coalesce(max(version), 0) from work_versions where work_id=?", rec.WorkID).Scan(&latest).Error; err != nil {
+			return err
+		}
+		rec.Version = latest + 1
+		return tx.Create(&rec).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("INFO: recorded version %d of work %s", rec.Version, rec.WorkID)
+	return &rec, nil
+}
+
+// recordBaseline saves a snapshot of a work that has no version history yet, so the state of the work
+// before its first tracked update can be restored
+func (svc *serviceContext) recordBaseline(tgtObj uvaeasystore.EasyStoreObject) {
+	var cnt int64
+	if err := svc.DB.Model(&workVersion{}).Where("work_id=?", tgtObj.Id()).Count(&cnt).Error; err != nil {
+		log.Printf("ERROR: unable to check version history of work %s: %s", tgtObj.Id(), err.Error())
+		return
+	}
+	if cnt > 0 {
+		return
+	}
+	createdAt := tgtObj.Modified()
+	if modDate, err := time.Parse(svc.TimeFormat, tgtObj.Fields()["modify-date"]); err == nil {
+		createdAt = modDate
+	}
+	if _, err := svc.recordVersion(systemComputeID, tgtObj, "baseline", createdAt); err != nil {
+		log.Printf("ERROR: unable to record baseline version of work %s: %s", tgtObj.Id(), err.Error())
+	}
+}
+
+func (svc *serviceContext) getWorkVersion(workID string, versionStr string) (*workVersion, *RequestError) {
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("%s is not a valid version", versionStr)}
+	}
+	var rec workVersion
+	resp := svc.DB.Where("work_id=? and version=?", workID, version).Limit(1).Find(&rec)
+	if resp.Error != nil {
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: resp.Error.Error()}
+	}
+	if resp.RowsAffected == 0 {
+		return nil, &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("version %d of %s was not found", version, workID)}
+	}
+	return &rec, nil
+}
+
+// getHistoryWork loads the work for the ID param and verifies the signed in user may see its version
+// history. The response has already been sent and nil is returned if not.
+func (svc *serviceContext) getHistoryWork(c *gin.Context) uvaeasystore.EasyStoreObject {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	if claims == nil {
+		log.Printf("INFO: reject anonymous version history request for work %s", workID)
+		c.String(http.StatusUnauthorized, "sign in is required")
+		return nil
+	}
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for version history: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return nil
+	}
+	if svc.isWorkMember(claims, tgtObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to get version history of work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, fmt.Sprintf("access to %s is not authorized", workID))
+		return nil
+	}
+	return tgtObj
+}
+
+func (svc *serviceContext) getVersions(c *gin.Context) {
+	tgtObj := svc.getHistoryWork(c)
+	if tgtObj == nil {
+		return
+	}
+	versions := make([]workVersion, 0)
+	if err := svc.DB.Where("work_id=?", tgtObj.Id()).Order("version desc").Find(&versions).Error; err != nil {
+		log.Printf("ERROR: unable to get versions of work %s: %s", tgtObj.Id(), err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// getVersionDiff lists the metadata and field changes between two versions of a work
+func (svc *serviceContext) getVersionDiff(c *gin.Context) {
+	tgtObj := svc.getHistoryWork(c)
+	if tgtObj == nil {
+		return
+	}
+	fromVer, reqErr := svc.getWorkVersion(tgtObj.Id(), c.Query("from"))
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	toVer, reqErr := svc.getWorkVersion(tgtObj.Id(), c.Query("to"))
+	if reqErr != nil {
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	diff, err := diffVersions(fromVer, toVer)
+	if err != nil {
+		log.Printf("ERROR: unable to diff versions %d and %d of work %s: %s", fromVer.Version, toVer.Version, tgtObj.Id(), err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// diffVersions compares the top level metadata attributes and the fields of two versions. Metadata
+// changes are named work.<attribute> and field changes fields.<name>.
+func diffVersions(fromVer, toVer *workVersion) (*versionDiff, error) {
+	resp := versionDiff{From: fromVer.Version, To: toVer.Version, Changes: make([]versionChange, 0)}
+
+	var fromMD, toMD map[string]any
+	if err := json.Unmarshal([]byte(fromVer.Metadata), &fromMD); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(toVer.Metadata), &toMD); err != nil {
+		return nil, err
+	}
+	for _, key := range unionKeys(fromMD, toMD) {
+		if reflect.DeepEqual(fromMD[key], toMD[key]) == false {
+			resp.Changes = append(resp.Changes, versionChange{Field: fmt.Sprintf("work.%s", key), Before: fromMD[key], After: toMD[key]})
+		}
+	}
+
+	var fromFields, toFields map[string]any
+	if err := json.Unmarshal([]byte(fromVer.Fields), &fromFields); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(toVer.Fields), &toFields); err != nil {
+		return nil, err
+	}
+	for _, key := range unionKeys(fromFields, toFields) {
+		if slices.Contains(unversionedFields, key) {
+			continue
+		}
+		if reflect.DeepEqual(fromFields[key], toFields[key]) == false {
+			resp.Changes = append(resp.Changes, versionChange{Field: fmt.Sprintf("fields.%s", key), Before: fromFields[key], After: toFields[key]})
+		}
+	}
+	return &resp, nil
+}
+
+func unionKeys(a, b map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, found := a[k]; found == false {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// adminRestoreVersion reverts the metadata and restorable fields of a work to a prior version. The
+// restore is recorded as a new version and audited like any other update.
+func (svc *serviceContext) adminRestoreVersion(c *gin.Context) {
+	workID := c.Param("id")
+	claims := getJWTClaims(c)
+	log.Printf("INFO: %s requests restore of work %s to version %s", claims.ComputeID, workID, c.Param("version"))
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata)
+	if err != nil {
+		log.Printf("ERROR: unable to get work %s for version restore: %s", workID, err.Error())
+		if strings.Contains(err.Error(), "not exist") {
+			c.String(http.StatusNotFound, fmt.Sprintf("%s was not found", workID))
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject version restore of deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
+	var verReq versionRequest
+	if bindOptionalJSON(c, &verReq) == false {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, verReq.Version) == false {
+		return
+	}
+
+	srcVer, reqErr := svc.getWorkVersion(workID, c.Param("version"))
+	if reqErr != nil {
+		log.Printf("INFO: unable to restore work %s: %s", workID, reqErr.Message)
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+	srcWork, err := librametadata.ETDWorkFromBytes([]byte(srcVer.Metadata))
+	if err != nil {
+		log.Printf("ERROR: unable to parse version %d of work %s: %s", srcVer.Version, workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	var srcFields map[string]string
+	if err := json.Unmarshal([]byte(srcVer.Fields), &srcFields); err != nil {
+		log.Printf("ERROR: unable to parse fields of version %d of work %s: %s", srcVer.Version, workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	svc.recordBaseline(tgtObj)
+
+	restoreReq := etdUpdateRequest{Work: *srcWork}
+	restoreReq.Visibility = srcFields["default-visibility"]
+	auditCtx := svc.auditWorkUpdate(claims.auditActor(), restoreReq, tgtObj)
+
+	fields := tgtObj.Fields()
+	priorRelease := fields["embargo-release"]
+	for _, name := range restorableFields {
+		if name != "default-visibility" && fields[name] != srcFields[name] {
+			auditEvt := uvalibrabus.UvaAuditEvent{Who: claims.ComputeID, FieldName: name, Before: fields[name], After: srcFields[name]}
+			svc.publishAuditChange(auditCtx, auditEvt)
+		}
+		if val, found := srcFields[name]; found {
+			fields[name] = val
+		} else {
+			delete(fields, name)
+		}
+	}
+	if fields["embargo-release"] != priorRelease {
+		delete(fields, "embargo-notice-sent")
+	}
+	fields["modify-date"] = time.Now().UTC().Format(svc.TimeFormat)
+	tgtObj.SetFields(fields)
+	tgtObj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(srcWork.MimeType(), []byte(srcVer.Metadata)))
+
+	if _, err := svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields|uvaeasystore.Metadata); err != nil {
+		log.Printf("ERROR: unable to restore work %s to version %d: %s", workID, srcVer.Version, err.Error())
+		svc.sendUpdateError(c, workID, err)
+		return
+	}
+	svc.commitAudits(auditCtx)
+	svc.publishEvent(uvalibrabus.EventObjectUpdate, svc.Namespace, workID)
+	if fields["draft"] == "false" && fields["doi"] != "" {
+		if doiErr := svc.updateDOIMetadata(tgtObj); doiErr != nil {
+			log.Printf("ERROR: unable to update doi metadata for restored work %s: %s", workID, doiErr.Error())
+		}
+	}
+
+	updatedObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.AllComponents)
+	if err != nil {
+		log.Printf("ERROR: unable to get restored work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	newVer, err := svc.recordVersion(claims.ComputeID, updatedObj, fmt.Sprintf("restored from version %d", srcVer.Version), time.Now())
+	if err != nil {
+		log.Printf("ERROR: unable to record restored version of work %s: %s", workID, err.Error())
+	} else {
+		auditEvt := uvalibrabus.UvaAuditEvent{
+			Who:       claims.ComputeID,
+			FieldName: "version",
+			Before:    strconv.Itoa(newVer.Version - 1),
+			After:     fmt.Sprintf("%d (restored from version %d)", newVer.Version, srcVer.Version),
+		}
+		svc.publishAuditChange(auditCtx, auditEvt)
+	}
+
+	resp, _ := svc.parseWork(updatedObj, true)
+	c.Header("ETag", fmt.Sprintf("\"%s\"", updatedObj.VTag()))
+	c.JSON(http.StatusOK, resp)
+}
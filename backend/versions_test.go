@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDiffVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		fromMD     string
+		toMD       string
+		fromFields string
+		toFields   string
+		want       []versionChange
+		wantErr    bool
+	}{
+		{
+			name:   "no changes",
+			fromMD: `{"title":"A"}`, toMD: `{"title":"A"}`,
+			fromFields: `{"draft":"true"}`, toFields: `{"draft":"true"}`,
+			want: []versionChange{},
+		},
+		{
+			name:   "metadata attribute changed",
+			fromMD: `{"title":"A","abstract":"x"}`, toMD: `{"title":"B","abstract":"x"}`,
+			fromFields: `{}`, toFields: `{}`,
+			want: []versionChange{{Field: "work.title", Before: "A", After: "B"}},
+		},
+		{
+			name:   "attributes added and removed are sorted",
+			fromMD: `{"title":"A","notes":"n"}`, toMD: `{"title":"A","keywords":["k"]}`,
+			fromFields: `{}`, toFields: `{}`,
+			want: []versionChange{
+				{Field: "work.keywords", Before: nil, After: []any{"k"}},
+				{Field: "work.notes", Before: "n", After: nil},
+			},
+		},
+		{
+			name:   "nested values compared deeply",
+			fromMD: `{"author":{"firstName":"Ann","lastName":"Lee"}}`, toMD: `{"author":{"firstName":"Anne","lastName":"Lee"}}`,
+			fromFields: `{}`, toFields: `{}`,
+			want: []versionChange{{Field: "work.author",
+				Before: map[string]any{"firstName": "Ann", "lastName": "Lee"},
+				After:  map[string]any{"firstName": "Anne", "lastName": "Lee"}}},
+		},
+		{
+			name:   "fields changed and modify date ignored",
+			fromMD: `{}`, toMD: `{}`,
+			fromFields: `{"default-visibility":"uva","modify-date":"2024-01-01T00:00:00Z"}`,
+			toFields:   `{"default-visibility":"open","modify-date":"2024-02-01T00:00:00Z"}`,
+			want:       []versionChange{{Field: "fields.default-visibility", Before: "uva", After: "open"}},
+		},
+		{
+			name:   "metadata changes precede field changes",
+			fromMD: `{"title":"A"}`, toMD: `{"title":"B"}`,
+			fromFields: `{"embargo-release":"2030-01-01T00:00:00Z"}`, toFields: `{}`,
+			want: []versionChange{
+				{Field: "work.title", Before: "A", After: "B"},
+				{Field: "fields.embargo-release", Before: "2030-01-01T00:00:00Z", After: nil},
+			},
+		},
+		{
+			name:   "invalid metadata",
+			fromMD: `{"title":`, toMD: `{}`, fromFields: `{}`, toFields: `{}`,
+			wantErr: true,
+		},
+		{
+			name:   "invalid fields",
+			fromMD: `{}`, toMD: `{}`, fromFields: `{}`, toFields: `[`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fromVer := workVersion{Version: 1, Metadata: tc.fromMD, Fields: tc.fromFields}
+			toVer := workVersion{Version: 2, Metadata: tc.toMD, Fields: tc.toFields}
+			got, err := diffVersions(&fromVer, &toVer)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("diffVersions succeeded; want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("diffVersions failed: %s", err.Error())
+			}
+			if got.From != 1 || got.To != 2 {
+				t.Errorf("diffVersions versions = %d to %d; want 1 to 2", got.From, got.To)
+			}
+			if reflect.DeepEqual(got.Changes, tc.want) == false {
+				t.Errorf("diffVersions changes = %+v; want %+v", got.Changes, tc.want)
+			}
+		})
+	}
+}
+
+func TestVersionHistoryRequiresSignIn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := serviceContext{}
+	router := gin.New()
+	router.GET("/api/works/:id/versions", svc.getVersions)
+	router.GET("/api/works/:id/versions/diff", svc.getVersionDiff)
+
+	for _, url := range []string{"/api/works/abc/versions", "/api/works/abc/versions/diff?from=1&to=2"} {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		if resp.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %s status = %d; want %d", url, resp.Code, http.StatusUnauthorized)
+		}
+	}
+}
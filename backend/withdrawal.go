@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	strip "github.com/grokify/html-strip-tags-go"
+	"github.com/uvalib/easystore/uvaeasystore"
+	"github.com/uvalib/librabus-sdk/uvalibrabus"
+	"gorm.io/gorm/clause"
+)
+
+// types of withdrawal shown on the tombstone page
+const (
+	withdrawnUnpublished = "unpublished"
+	withdrawnDeleted     = "deleted"
+)
+
+// withdrawal records a work that was once public but has since been unpublished or deleted. The
+// public view renders a tombstone from this record so DOIs and citations that point at the work still
+// resolve to a page that explains what happened. Records survive a trash purge for the same reason.
+type withdrawal struct {
+	ID            uint64
+	WorkID        string
+	Namespace     string
+	Title         string
+	Author        string
+	DOI           string `gorm:"column:doi"`
+	Reason        string
+	WithdrawnType string
+	WithdrawnBy   string
+	WithdrawnAt   time.Time
+}
+
+// withdrawRequest is the optional payload for unpublish and delete requests
+type withdrawRequest struct {
+	versionRequest
+	Reason string `json:"reason"`
+}
+
+// recordWithdrawal creates or replaces the withdrawal record for a work. When no reason is supplied
+// any reason given by an earlier withdrawal of the same work is kept; a new reason is audited.
+func (svc *serviceContext) recordWithdrawal(actor auditActor, tgtObj uvaeasystore.EasyStoreObject, withdrawnType string, reason string) {
+	rec := withdrawal{
+		WorkID:        tgtObj.Id(),
+		Namespace:     svc.Namespace,
+		DOI:           tgtObj.Fields()["doi"],
+		Reason:        strings.TrimSpace(reason),
+		WithdrawnType: withdrawnType,
+		WithdrawnBy:   actor.computeID,
+		WithdrawnAt:   time.Now(),
+	}
+	if work, err := svc.parseWork(tgtObj, false); err != nil {
+		log.Printf("WARNING: unable to parse work %s for withdrawal details: %s", rec.WorkID, err.Error())
+	} else {
+		rec.Title = work.Title
+		rec.Author = fmt.Sprintf("%s, %s", work.Author.LastName, work.Author.FirstName)
+	}
+
+	priorReason := ""
+	if prior := svc.getWithdrawal(rec.WorkID); prior != nil {
+		priorReason = prior.Reason
+	}
+
+	updateCols := []string{"namespace", "title", "author", "doi", "withdrawn_type", "withdrawn_by", "withdrawn_at"}
+	if rec.Reason != "" {
+		updateCols = append(updateCols, "reason")
+	}
+	err := svc.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "work_id"}},
+		DoUpdates: clause.AssignmentColumns(updateCols),
+	}).Create(&rec).Error
+	if err != nil {
+		log.Printf("ERROR: unable to record %s withdrawal of work %s: %s", withdrawnType, rec.WorkID, err.Error())
+		return
+	}
+	if rec.Reason != "" && rec.Reason != priorReason {
+		auditEvt := uvalibrabus.UvaAuditEvent{
+			Who:       actor.computeID,
+			FieldName: "withdrawal-reason",
+			Before:    priorReason,
+			After:     rec.Reason,
+		}
+		svc.publishAuditEvent(actor, svc.Namespace, rec.WorkID, auditEvt)
+	}
+}
+
+func (svc *serviceContext) clearWithdrawal(workID string) {
+	if err := svc.DB.Where("work_id=?", workID).Delete(&withdrawal{}).Error; err != nil {
+		log.Printf("ERROR: unable to remove withdrawal record for work %s: %s", workID, err.Error())
+	}
+}
+
+func (svc *serviceContext) getWithdrawal(workID string) *withdrawal {
+	var rec withdrawal
+	resp := svc.DB.Where("work_id=?", workID).Limit(1).Find(&rec)
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to get withdrawal record for work %s: %s", workID, resp.Error.Error())
+		return nil
+	}
+	if resp.RowsAffected == 0 {
+		return nil
+	}
+	return &rec
+}
+
+// renderTombstone serves the public view of a withdrawn work with a 410 status
+func (svc *serviceContext) renderTombstone(c *gin.Context, rec *withdrawal) {
+	log.Printf("INFO: work %s was %s on %s; render tombstone", rec.WorkID, rec.WithdrawnType, rec.WithdrawnAt.Format("2006-01-02"))
+	var tombstoneData struct {
+		CleanTitle    string
+		Title         template.HTML
+		Author        string
+		DOI           string
+		Reason        string
+		WithdrawnType string
+		WithdrawnDate string
+		ThisYear      string
+	}
+	tombstoneData.CleanTitle = strip.StripTags(rec.Title)
+	tombstoneData.Title = template.HTML(rec.Title)
+	tombstoneData.Author = rec.Author
+	if doi := doiFromField(rec.DOI); doi != "" {
+		tombstoneData.DOI = fmt.Sprintf("%s%s", doiResolverURL, doi)
+	}
+	tombstoneData.Reason = rec.Reason
+	tombstoneData.WithdrawnType = rec.WithdrawnType
+	tombstoneData.WithdrawnDate = rec.WithdrawnAt.Format("January 2, 2006")
+	tombstoneData.ThisYear = fmt.Sprintf("%d", time.Now().Year())
+	c.Header("X-Robots-Tag", "noindex, nofollow, noarchive")
+	c.HTML(http.StatusGone, "tombstone.html", tombstoneData)
+}
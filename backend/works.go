@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -38,6 +40,7 @@ type coreWorkDetails struct {
 	CreatedAt      time.Time      `json:"createdAt"`
 	ModifiedAt     string         `json:"modifiedAt,omitempty"`
 	PublishedAt    string         `json:"publishedAt,omitempty"`
+	Review         *reviewDetails `json:"review,omitempty"`
 }
 
 type workDetails struct {
@@ -76,7 +79,9 @@ func (svc *serviceContext) getWorkHandler(c *gin.Context) {
 			log.Printf("ERROR: get work %s failed: %d - %s", workID, err.StatusCode, err.Message)
 			c.String(err.StatusCode, err.Message)
 		}
+		return
 	}
+	c.Header("ETag", fmt.Sprintf("\"%s\"", etdWork.Version))
 	c.JSON(http.StatusOK, etdWork)
 }
 
@@ -94,6 +99,14 @@ func (svc *serviceContext) getWork(c *gin.Context, workID, reason string) (*work
 		return nil, &workError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
 	}
 
+	// works in the trash are gone for everyone but admins, and are never shown in the public view
+	if isTrashed(tgtObj) {
+		claims := getJWTClaims(c)
+		if reason == "view" || claims == nil || claims.isAdmin() == false {
+			return nil, &workError{StatusCode: http.StatusGone, Message: fmt.Sprintf("%s has been deleted", workID)}
+		}
+	}
+
 	log.Printf("INFO: check access to work %s", workID)
 	access := svc.canAccessWork(c, tgtObj)
 	if access.metadata == false {
@@ -104,6 +117,9 @@ func (svc *serviceContext) getWork(c *gin.Context, workID, reason string) (*work
 	if err != nil {
 		return nil, &workError{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("unable to parse work %s: %s", workID, err.Error())}
 	}
+	if etdWork.IsDraft {
+		etdWork.Review = svc.getReviewDetails(tgtObj, etdWork)
+	}
 
 	// when requested for public view of a published work, trigger a view event
 	if reason == "view" && etdWork.IsDraft == false && etdWork.PublishedAt != "" {
@@ -116,14 +132,7 @@ func (svc *serviceContext) getWork(c *gin.Context, workID, reason string) (*work
 			Detail:     viewDetail,
 		}
 		log.Printf("INFO: publish view event %s", viewDetail)
-		if svc.Events.DevMode {
-			log.Printf("INFO: dev mode work %s send view event to bus [%s] with source [%s]", workID, svc.Events.BusName, svc.Events.EventSource)
-		} else {
-			err := svc.Events.Bus.PublishEvent(&evt)
-			if err != nil {
-				log.Printf("ERROR: unable to publish view event %+v : %s", evt, err.Error())
-			}
-		}
+		svc.queueEvent(&evt)
 
 		metrics, mErr := svc.getPublicViewMetrics(workID)
 		if mErr != nil {
@@ -198,13 +207,33 @@ func (svc *serviceContext) updateWork(c *gin.Context) {
 
 	// NOTE: this call has already been thru user or admin middleware, so claims will be present
 	claims := getJWTClaims(c)
-	if (claims.ComputeID == tgtObj.Fields()["depositor"] || claims.isAdmin()) == false {
+	if svc.canEditWork(claims, tgtObj) == false {
 		log.Printf("INFO: unauthorized attempt to update work %s", workID)
 		c.String(http.StatusForbidden, "you do not have permission to update this work")
 		return
 	}
 
-	svc.auditWorkUpdate(claims.ComputeID, etdReq, tgtObj)
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject update of deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
+	if svc.isCurrentVersion(c, tgtObj, etdReq.Version) == false {
+		return
+	}
+
+	valErrs, err := svc.validateWorkUpdate(claims, tgtObj, &etdReq)
+	if err != nil {
+		log.Printf("ERROR: unable to validate update of work %s: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(valErrs) > 0 {
+		log.Printf("INFO: reject update of work %s with %d invalid fields: %v", workID, len(valErrs), valErrs)
+		c.JSON(http.StatusBadRequest, valErrs)
+		return
+	}
 
 	// An ETDWork does not serialize the same way as an EasyStoreMetadata object
 	// does when being managed by json.Marshal/json.Unmarshal so we wrap it in an object that
@@ -215,8 +244,6 @@ func (svc *serviceContext) updateWork(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	tgtObj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(etdReq.Work.MimeType(), pl))
-
 	etdWork, err := librametadata.ETDWorkFromBytes(pl)
 	if err != nil {
 		log.Printf("ERROR: unable parse work %s: %s", workID, err.Error())
@@ -224,39 +251,31 @@ func (svc *serviceContext) updateWork(c *gin.Context) {
 		return
 	}
 
+	origAdvisors := ""
+	if origWork, err := svc.parseWork(tgtObj, false); err == nil {
+		origAdvisors = strings.Join(advisorComputeIDs(origWork.ETDWork), ",")
+	}
+
+	// every rejection happens above; from here on the update is audited and saved. audits are collected
+	// and only stored once the save succeeds
+	svc.recordBaseline(tgtObj)
+	auditCtx := svc.auditWorkUpdate(claims.auditActor(), etdReq, tgtObj)
+	tgtObj.SetMetadata(uvaeasystore.NewEasyStoreMetadata(etdReq.Work.MimeType(), pl))
+
 	// update fields
 	fields := tgtObj.Fields()
 	fields["modify-date"] = time.Now().UTC().Format(svc.TimeFormat)
 	fields["default-visibility"] = etdReq.Visibility
+	priorRelease := fields["embargo-release"]
+	priorReleaseVisibility := fields["embargo-release-visibility"]
 	if etdReq.Visibility == "uva" || etdReq.Visibility == "embargo" {
 		if etdReq.EmbargoReleaseDate == "" {
 			log.Printf("INFO: work %s set for a forever embargo", tgtObj.Id())
 			fields["embargo-release"] = ""
 			delete(fields, "embargo-release-visibility")
 		} else {
-			// For non-admin users, visibility must be public within 5 years per provost
-			endDate, dateErr := time.Parse(svc.TimeFormat, etdReq.EmbargoReleaseDate)
-			if dateErr != nil {
-				log.Printf("INFO: reject invalid limited visibiity end date %s: %s", etdReq.EmbargoReleaseDate, dateErr.Error())
-				c.String(http.StatusBadRequest, fmt.Sprintf("invalid end date: %s", etdReq.EmbargoReleaseDate))
-				return
-			}
-			if etdReq.Visibility == "uva" && claims.isAdmin() == false {
-				maxYears := 5
-				if etdWork.Program == "Creative Writing" && etdWork.Degree == "MFA (Master of Fine Arts)" {
-					log.Printf("INFO: %s is for MFA in creative writing; allow 10 year limited visibility", workID)
-					maxYears = 10
-				}
-				maxDate := time.Now().UTC().AddDate(maxYears, 0, 0) // now + 5 years
-				maxDate = maxDate.Truncate(24 * time.Hour)
-				truncEndDate := endDate.UTC().Truncate(24 * time.Hour)
-				log.Printf("INFO: truncated release date (yyyy-mm-dd only) comparison: release %s vs 5 years %s", truncEndDate, maxDate)
-				if truncEndDate.After(maxDate) {
-					log.Printf("INFO: reject limited visibility end date longer than 5 years: %s", etdReq.EmbargoReleaseDate)
-					c.String(http.StatusBadRequest, "limited visibilty end date must be less than five years from today")
-					return
-				}
-			}
+			// the date and limits on it were checked by validateWorkUpdate
+			endDate, _ := time.Parse(svc.TimeFormat, etdReq.EmbargoReleaseDate)
 			fields["embargo-release"] = endDate.UTC().Format(svc.TimeFormat)
 			fields["embargo-release-visibility"] = etdReq.EmbargoReleaseVisibility
 		}
@@ -264,14 +283,46 @@ func (svc *serviceContext) updateWork(c *gin.Context) {
 		delete(fields, "embargo-release")
 		delete(fields, "embargo-release-visibility")
 	}
+	if fields["embargo-release"] != priorRelease {
+		svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: claims.ComputeID, FieldName: "embargo-release", Before: priorRelease, After: fields["embargo-release"]})
+		// a new release date gets its own expiration notice
+		delete(fields, "embargo-notice-sent")
+	}
+	if fields["embargo-release-visibility"] != priorReleaseVisibility {
+		svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: claims.ComputeID, FieldName: "embargo-release-visibility",
+			Before: priorReleaseVisibility, After: fields["embargo-release-visibility"]})
+	}
+	if fields["review-status"] == reviewApproved && fields["draft"] == "true" && claims.isAdmin() == false {
+		// an approval covers the work as it was reviewed; changes made afterwards must be approved again
+		log.Printf("INFO: work %s changed after advisor approval; review is required again", workID)
+		clearReview(fields)
+		svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: claims.ComputeID, FieldName: "review-status", Before: reviewApproved})
+	} else if fields["review-status"] == reviewPending && strings.Join(advisorComputeIDs(etdWork), ",") != origAdvisors {
+		// the work was sent to the advisors listed at submission; a new list must be submitted again
+		log.Printf("INFO: advisors of work %s changed while pending review; review is required again", workID)
+		clearReview(fields)
+		svc.publishAuditChange(auditCtx, uvalibrabus.UvaAuditEvent{Who: claims.ComputeID, FieldName: "review-status", Before: reviewPending})
+	}
 	tgtObj.SetFields(fields)
 
 	_, err = svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields|uvaeasystore.Metadata)
 	if err != nil {
 		log.Printf("ERROR: unable to update work %s: %s", workID, err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		svc.sendUpdateError(c, workID, err)
 		return
 	}
+	svc.commitAudits(auditCtx)
+	svc.publishEvent(uvalibrabus.EventObjectUpdate, svc.Namespace, workID)
+
+	// published works must keep the DataCite metadata in sync once the update is saved; works published
+	// while DataCite was unavailable will not have a DOI yet, so register one now
+	if fields["draft"] == "false" {
+		if fields["doi"] == "" {
+			svc.registerWorkDOI(claims.auditActor(), workID)
+		} else if doiErr := svc.updateDOIMetadata(tgtObj); doiErr != nil {
+			log.Printf("ERROR: unable to update doi metadata for work %s: %s", workID, doiErr.Error())
+		}
+	}
 
 	// reload work to get latest files and vtag
 	log.Printf("INFO: get %s work %s", svc.Namespace, workID)
@@ -281,7 +332,11 @@ func (svc *serviceContext) updateWork(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if _, err := svc.recordVersion(claims.ComputeID, updatedObj, "", time.Now()); err != nil {
+		log.Printf("ERROR: unable to record version of work %s: %s", workID, err.Error())
+	}
 	resp, _ := svc.parseWork(updatedObj, true)
+	c.Header("ETag", fmt.Sprintf("\"%s\"", updatedObj.VTag()))
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -292,7 +347,7 @@ func (svc *serviceContext) publishWork(c *gin.Context) {
 	claims := getJWTClaims(c)
 
 	log.Printf("INFO: %s requests work %s publish", claims.ComputeID, workID)
-	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.BaseComponent|uvaeasystore.Fields|uvaeasystore.Metadata|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: unable to get work %s: %s", workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
@@ -305,6 +360,20 @@ func (svc *serviceContext) publishWork(c *gin.Context) {
 		return
 	}
 
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject publish of deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
+	var verReq versionRequest
+	if bindOptionalJSON(c, &verReq) == false {
+		return
+	}
+	if svc.isCurrentVersion(c, tgtObj, verReq.Version) == false {
+		return
+	}
+
 	fields := tgtObj.Fields()
 	if fields["draft"] == "false" {
 		log.Printf("INFO: %s is already published", workID)
@@ -312,17 +381,48 @@ func (svc *serviceContext) publishWork(c *gin.Context) {
 		return
 	}
 
-	svc.auditPublicationChange(claims.ComputeID, tgtObj, true)
+	pubWork, err := svc.parseWork(tgtObj, false)
+	if err != nil {
+		log.Printf("ERROR: unable to parse work %s to check publication readiness: %s", workID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if readiness := svc.checkReadiness(pubWork.ETDWork, tgtObj.Files()); readiness.Ready == false {
+		log.Printf("INFO: reject publish of work %s with %d readiness errors", workID, len(readiness.Errors))
+		c.JSON(http.StatusUnprocessableEntity, readiness)
+		return
+	}
+	reviewRequired, err := svc.isReviewRequired(pubWork.Program)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if fields["review-status"] != reviewApproved && reviewRequired {
+		log.Printf("INFO: reject publish of work %s without advisor approval; review status [%s]", workID, fields["review-status"])
+		c.String(http.StatusConflict, fmt.Sprintf("%s requires advisor approval before it can be published", pubWork.Program))
+		return
+	}
 
 	fields["draft"] = "false"
 	fields["publish-date"] = time.Now().UTC().Format(svc.TimeFormat)
 	_, err = svc.EasyStore.ObjectUpdate(tgtObj, uvaeasystore.Fields)
 	if err != nil {
 		log.Printf("ERROR: publish %s failed: %s", workID, err.Error())
-		c.String(http.StatusInternalServerError, fmt.Sprintf("publish failed: %s", err.Error()))
+		svc.sendUpdateError(c, workID, fmt.Errorf("publish failed: %w", err))
 		return
 	}
+	svc.auditPublicationChange(claims.auditActor(), tgtObj, true)
+	// the work was parsed before the DOI was minted; the notice must include it
+	if doi := svc.registerWorkDOI(claims.auditActor(), workID); doi != "" {
+		pubWork.PersistentLink = doi
+	}
 	svc.publishEvent(uvalibrabus.EventWorkPublish, svc.Namespace, tgtObj.Id())
+	svc.clearWithdrawal(tgtObj.Id())
+
+	if err := svc.sendNotification(claims.auditActor(), workID, submittedNotice, depositorEmail(tgtObj), svc.newEmailData(pubWork)); err != nil {
+		log.Printf("ERROR: unable to send submission notice for work %s: %s", workID, err.Error())
+	}
 
 	if fields["source"] == "optional" {
 		log.Printf("INFO: update registrations database to flag optional work %s as complete", workID)
@@ -340,6 +440,79 @@ func (svc *serviceContext) publishWork(c *gin.Context) {
 	c.String(http.StatusOK, "published")
 }
 
+// versionRequest is the optional payload for requests that only carry the work version the client last saw
+type versionRequest struct {
+	Version string `json:"version"`
+}
+
+// bindOptionalJSON binds an optional request payload. A missing payload is not an error; a payload that
+// cannot be parsed gets a 400 response and false is returned.
+func bindOptionalJSON(c *gin.Context, req any) bool {
+	if err := c.ShouldBindJSON(req); err != nil && errors.Is(err, io.EOF) == false {
+		log.Printf("ERROR: bad payload for %s: %s", c.Request.URL.Path, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// isCurrentVersion checks the work version the client based a change on against the current easystore vtag.
+// The version comes from the If-Match header or the request payload; when neither is present the check is skipped.
+// When the vtag has moved on, a 412 response containing the current work is sent and false is returned.
+func (svc *serviceContext) isCurrentVersion(c *gin.Context, tgtObj uvaeasystore.EasyStoreObject, payloadVersion string) bool {
+	clientVersion := strings.TrimPrefix(strings.TrimSpace(c.GetHeader("If-Match")), "W/")
+	clientVersion = strings.Trim(clientVersion, "\"")
+	if clientVersion == "" || clientVersion == "*" {
+		clientVersion = payloadVersion
+	}
+	if clientVersion == "" {
+		log.Printf("INFO: no version supplied for change to work %s; skip version check", tgtObj.Id())
+		return true
+	}
+	if clientVersion == tgtObj.VTag() {
+		return true
+	}
+
+	log.Printf("INFO: version %s for work %s is stale; current version is %s", clientVersion, tgtObj.Id(), tgtObj.VTag())
+	svc.sendCurrentWork(c, tgtObj.Id(), fmt.Sprintf("%s has been modified since version %s", tgtObj.Id(), clientVersion))
+	return false
+}
+
+// isStaleObjectError returns true if easystore rejected an update because the object has changed since it was loaded
+func isStaleObjectError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "stale")
+}
+
+// sendUpdateError responds to a failed easystore update of a work. An update rejected because another change was
+// saved after the work was loaded gets the same 412 response with the current work as a stale client version.
+func (svc *serviceContext) sendUpdateError(c *gin.Context, workID string, err error) {
+	if isStaleObjectError(err) {
+		log.Printf("INFO: work %s was modified by another change while this one was saved", workID)
+		svc.sendCurrentWork(c, workID, fmt.Sprintf("%s has been modified by another change", workID))
+		return
+	}
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+// sendCurrentWork sends a 412 response containing the current version of a work. The message is sent instead
+// if the current version cannot be loaded.
+func (svc *serviceContext) sendCurrentWork(c *gin.Context, workID string, message string) {
+	currObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.AllComponents)
+	if err != nil {
+		log.Printf("ERROR: unable to get current version of work %s: %s", workID, err.Error())
+		c.String(http.StatusPreconditionFailed, message)
+		return
+	}
+	currWork, err := svc.parseWork(currObj, true)
+	if err != nil {
+		log.Printf("ERROR: unable to parse current version of work %s: %s", workID, err.Error())
+		c.String(http.StatusPreconditionFailed, message)
+		return
+	}
+	c.Header("ETag", fmt.Sprintf("\"%s\"", currObj.VTag()))
+	c.JSON(http.StatusPreconditionFailed, currWork)
+}
+
 func (svc *serviceContext) isFromUVA(c *gin.Context) bool {
 	fromUVA := false
 	// clientIP does its best guess to determine the real client request IP
@@ -405,7 +578,7 @@ func (svc *serviceContext) renameFile(c *gin.Context) {
 	}
 	log.Printf("INFO: request to rename file %s from work %s to %s", renameReq.OriginalName, workID, renameReq.NewName)
 
-	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Files)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: unable to get %s work %s for file rename: %s", svc.Namespace, workID, err.Error())
 		if strings.Contains(err.Error(), "not exist") {
@@ -416,6 +589,25 @@ func (svc *serviceContext) renameFile(c *gin.Context) {
 		return
 	}
 
+	// NOTE: this call has already been thru user or admin middleware, so claims will be present
+	claims := getJWTClaims(c)
+	if svc.canEditWork(claims, tgtObj) == false {
+		log.Printf("INFO: unauthorized attempt by %s to rename a file in work %s", claims.ComputeID, workID)
+		c.String(http.StatusForbidden, "you do not have permission to rename files in this work")
+		return
+	}
+
+	if isTrashed(tgtObj) {
+		log.Printf("INFO: reject file rename in deleted work %s", workID)
+		c.String(http.StatusGone, fmt.Sprintf("%s has been deleted", workID))
+		return
+	}
+
+	if err := svc.clearApprovalForFileChange(claims, tgtObj); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	rnErr := svc.EasyStore.FileRename(svc.Namespace, tgtObj.Id(), renameReq.OriginalName, renameReq.NewName)
 	if rnErr != nil {
 		log.Printf("ERROR: rename %s to %s failed: %s", renameReq.OriginalName, renameReq.NewName, rnErr.Error())
@@ -423,9 +615,7 @@ func (svc *serviceContext) renameFile(c *gin.Context) {
 		return
 	}
 
-	// NOTE: this call has already been thru user or admin middleware, so claims will be present
-	claims := getJWTClaims(c)
-	svc.auditFileRename(claims.ComputeID, tgtObj, renameReq.OriginalName, renameReq.NewName)
+	svc.auditFileRename(claims.auditActor(), tgtObj, renameReq.OriginalName, renameReq.NewName)
 
 	c.String(http.StatusOK, renameReq.NewName)
 }
@@ -434,12 +624,17 @@ func (svc *serviceContext) downloadDraftFile(c *gin.Context) {
 	workID := c.Param("id")
 	tgtFile := c.Param("name")
 	log.Printf("INFO: request to download file %s from draft work %s", tgtFile, workID)
-	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Files)
+	tgtObj, err := svc.EasyStore.ObjectGetByKey(svc.Namespace, workID, uvaeasystore.Fields|uvaeasystore.Files)
 	if err != nil {
 		log.Printf("ERROR: get %s work %s for download failed: %s", svc.Namespace, workID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if svc.canAccessWork(c, tgtObj).files == false {
+		log.Printf("INFO: file %s from work %s is not available to the current user", tgtFile, workID)
+		c.String(http.StatusForbidden, fmt.Sprintf("access to %s is not authorized", tgtFile))
+		return
+	}
 
 	var dlFile uvaeasystore.EasyStoreBlob
 	for _, oaFile := range tgtObj.Files() {
@@ -475,7 +670,7 @@ func getPublicRequestEventDetails(c *gin.Context, targetID string) []byte {
 
 func (svc *serviceContext) canAccessWork(c *gin.Context, tgtObj uvaeasystore.EasyStoreObject) workAccess {
 	// enforce visibility; (admins/authors can always view all)
-	//    METADATA: visible to all - except draft content is author/admin only
+	//    METADATA: visible to all - except draft content is author/admin/collaborator only
 	//    FILES: open = visible to all; uva = only visible to uva for a limited timeframe; embargo: only author and admin until date
 	fields := tgtObj.Fields()
 	visibility := svc.calculateVisibility(fields["default-visibility"], fields["embargo-release"], fields["embargo-release-visibility"])
@@ -496,6 +691,15 @@ func (svc *serviceContext) canAccessWork(c *gin.Context, tgtObj uvaeasystore.Eas
 			resp.files = true
 			return resp
 		}
+		if isDraft {
+			// collaborators have been granted access to the draft by the author or an admin; once the work
+			// is published they follow the visibility rules like everyone else so embargoed files stay hidden
+			if collab := svc.getCollaborator(tgtObj.Id(), jwt.ComputeID); collab != nil {
+				log.Printf("INFO: %s is a %s collaborator on draft %s and has full access from %s", userInfo, collab.Permission, tgtObj.Id(), clientIP)
+				resp.files = true
+				return resp
+			}
+		}
 	} else {
 		log.Printf("INFO: work %s accessed by %s", tgtObj.Id(), userInfo)
 	}
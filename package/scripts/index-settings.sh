@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+#
+# apply any necessary search index settings. changing filterable attributes causes
+# the index to be rebuilt, so this is run as a deploy step and not at service startup
+#
+
+if [ -z "$INDEX_URL" ]; then
+   echo "INDEX_URL is not defined; skipping index settings"
+   exit 0
+fi
+
+SETTINGS_URL=$INDEX_URL/indexes/works/settings/filterable-attributes
+
+# get the current filterable attributes
+CURRENT=$(curl -sf $SETTINGS_URL)
+if [ $? -ne 0 ]; then
+   echo "ERROR: unable to get filterable attributes from $SETTINGS_URL"
+   exit 1
+fi
+
+# deleted works are excluded from all searches with a filter on fields.deleted, the embargo
+# processor filters on fields.embargo-release and OAI-PMH sets filter on metadata.program and
+# metadata.degree. keep in sync with indexFilterAttributes in backend/service.go
+if echo "$CURRENT" | grep -q '"\*"'; then
+   echo "all attributes are filterable; no update needed"
+   exit 0
+fi
+
+UPDATED=$(echo $CURRENT | tr -d '[:space:]')
+for ATTR in fields.deleted fields.embargo-release metadata.program metadata.degree; do
+   # an attribute is also filterable when its parent object is
+   PARENT=${ATTR%%.*}
+   if echo "$UPDATED" | grep -q -e "\"$ATTR\"" -e "\"$PARENT\""; then
+      continue
+   fi
+   if [ "$UPDATED" == "[]" ]; then
+      UPDATED="[\"$ATTR\"]"
+   else
+      UPDATED=$(echo $UPDATED | sed -e "s/]$/,\"$ATTR\"]/")
+   fi
+done
+
+if [ "$UPDATED" == "$(echo $CURRENT | tr -d '[:space:]')" ]; then
+   echo "filterable attributes are up to date; no update needed"
+   exit 0
+fi
+
+echo "update filterable attributes: $UPDATED"
+curl -sf -X PUT -H "Content-Type: application/json" -d "$UPDATED" $SETTINGS_URL
+
+# return the status
+exit $?
+
+#
+# end of file
+#
@@ -5,6 +5,13 @@
 
 # run the migrations
 bin/migrate -path db -verbose -database postgres://$DBUSER:$DBPASS@$DBHOST:$DBPORT/$DBNAME up
+res=$?
+if [ $res -ne 0 ]; then
+   exit $res
+fi
+
+# apply any search index settings
+scripts/index-settings.sh
 
 # return the status
 exit $?